@@ -2,18 +2,101 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 
 	"github.com/julien-sobczak/linux-packages-from-scratch/internal/apt"
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
 )
 
 func main() {
 	var flagInstall bool
+	var flagMirror bool
+	var flagSearch bool
+	var flagShow bool
+	var flagPolicy bool
+	var flagNamesOnly bool
+	var flagFull bool
+	var flagDepends bool
+	var flagRDepends bool
+	var flagDotty bool
+	var flagRecurse bool
+	var flagInstalled bool
+	var flagFormat string
+	var flagRemove bool
+	var flagPurge bool
+	var flagAutoRemove bool
+	var flagMark string
+	var flagUpgrade bool
+	var flagFullUpgrade bool
+	var flagClean bool
+	var flagAutoClean bool
+	var flagFixBroken bool
 	flag.BoolVar(&flagInstall, "install", false, "Install a debian package")
+	flag.BoolVar(&flagMirror, "mirror", false, "Mirror repositories using a mirror config file")
+	flag.BoolVar(&flagSearch, "search", false, "Search packages whose name or description matches the regex")
+	flag.BoolVar(&flagShow, "show", false, "Show all versions of a package")
+	flag.BoolVar(&flagPolicy, "policy", false, "Show installed and candidate versions of a package")
+	flag.BoolVar(&flagNamesOnly, "names-only", false, "Search only package names (with --search)")
+	flag.BoolVar(&flagFull, "full", false, "Print full records (with --search)")
+	flag.BoolVar(&flagDepends, "depends", false, "Show the dependencies of a package")
+	flag.BoolVar(&flagRDepends, "rdepends", false, "Show the reverse dependencies of a package")
+	flag.BoolVar(&flagDotty, "dotty", false, "Export the dependency graph of packages")
+	flag.BoolVar(&flagRecurse, "recurse", false, "Show dependencies recursively (with --depends/--rdepends)")
+	flag.BoolVar(&flagInstalled, "installed", false, "Limit to installed packages (with --depends/--rdepends/--dotty)")
+	flag.StringVar(&flagFormat, "format", "dot", "Output format: dot or json (with --dotty)")
+	flag.BoolVar(&flagRemove, "remove", false, "Remove packages")
+	flag.BoolVar(&flagPurge, "purge", false, "Remove packages and their configuration files")
+	flag.BoolVar(&flagAutoRemove, "autoremove", false, "Remove packages automatically installed and no longer required")
+	flag.StringVar(&flagMark, "mark", "", "Mark packages: auto, manual, showauto or showmanual")
+	flag.BoolVar(&flagUpgrade, "upgrade", false, "Upgrade installed packages without installing or removing packages")
+	flag.BoolVar(&flagFullUpgrade, "full-upgrade", false, "Upgrade installed packages, installing or removing packages if needed")
+	flag.BoolVar(&apt.AssumeYes, "y", false, "Assume yes to all prompts")
+	flag.BoolVar(&apt.AssumeYes, "assume-yes", false, "Assume yes to all prompts")
+	flag.BoolVar(&apt.Simulate, "s", false, "Simulate the actions without touching the system")
+	flag.BoolVar(&apt.Simulate, "simulate", false, "Simulate the actions without touching the system")
+	flag.BoolVar(&apt.DownloadOnly, "download-only", false, "Download packages without installing them")
+	flag.BoolVar(&apt.NoDownload, "no-download", false, "Use only the files already downloaded")
+	flag.BoolVar(&flagClean, "clean", false, "Remove all downloaded archives")
+	flag.BoolVar(&flagAutoClean, "autoclean", false, "Remove downloaded archives that can no longer be downloaded")
+	flag.DurationVar(&dpkg.LockTimeout, "lock-timeout", 0, "Wait at most this duration (ex: 30s) for locks held by other processes")
+	flag.BoolVar(&flagFixBroken, "fix-broken", false, "Complete interrupted installations and install missing dependencies")
+	flag.BoolVar(&flagFixBroken, "f", false, "Complete interrupted installations and install missing dependencies (shorthand)")
 	flag.Parse()
 	args := flag.Args()
 
-	if flagInstall {
+	if flagFixBroken {
+		apt.FixBroken()
+	} else if flagInstall {
 		apt.Install(args)
+	} else if flagMirror {
+		if len(args) < 1 {
+			fmt.Printf("Missing mirror config file\n")
+			os.Exit(1)
+		}
+		apt.Mirror(args[0])
+	} else if flagSearch {
+		apt.Search(args, flagNamesOnly, flagFull)
+	} else if flagShow {
+		apt.Show(args)
+	} else if flagPolicy {
+		apt.Policy(args)
+	} else if flagDepends {
+		apt.Depends(args, flagRecurse, flagInstalled)
+	} else if flagRDepends {
+		apt.RDepends(args, flagRecurse, flagInstalled)
+	} else if flagDotty {
+		apt.Dotty(args, flagFormat, flagInstalled)
+	} else if flagRemove || flagPurge || flagAutoRemove {
+		apt.Remove(args, flagPurge, flagAutoRemove)
+	} else if flagMark != "" {
+		apt.Mark(flagMark, args)
+	} else if flagUpgrade || flagFullUpgrade {
+		apt.Upgrade(flagFullUpgrade)
+	} else if flagClean {
+		apt.Clean()
+	} else if flagAutoClean {
+		apt.AutoClean()
 	}
 
 }
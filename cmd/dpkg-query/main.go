@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+)
+
+func main() {
+	var flagShow bool
+	var flagJSON bool
+	var flagFormat string
+	// Other queries (--list, --status, --listfiles, --search) are supported by dpkg
+	flag.BoolVar(&flagShow, "show", false, "Show the packages matching the patterns using a format")
+	flag.BoolVar(&flagShow, "W", false, "Show the packages matching the patterns using a format (shorthand)")
+	flag.StringVar(&flagFormat, "showformat", dpkg.DefaultShowFormat, "Format used by --show (ex: ${Package}\\t${Version}\\n)")
+	flag.StringVar(&flagFormat, "f", dpkg.DefaultShowFormat, "Format used by --show (shorthand)")
+	flag.BoolVar(&flagJSON, "json", false, "Show the packages as JSON instead of using a format (with --show)")
+	flag.Parse()
+	args := flag.Args()
+
+	formatSet := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "showformat" || f.Name == "f" {
+			formatSet = true
+		}
+	})
+
+	if !flagShow {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if flagJSON && formatSet {
+		fmt.Printf("dpkg-query: --json and --showformat are mutually exclusive\n")
+		os.Exit(2)
+	}
+
+	if flagJSON {
+		dpkg.ShowJSON(args)
+	} else {
+		dpkg.Show(args, flagFormat)
+	}
+
+}
@@ -10,9 +10,70 @@ import (
 
 func main() {
 	var flagBuild bool
+	var flagBuildSpec bool
 	var flagInstall bool
+	var flagRemove bool
+	var flagPurge bool
+	var flagUnpack bool
+	var flagConfigure bool
+	var flagPending bool
+	var flagAudit bool
+	var flagVerify bool
+	var flagList bool
+	var flagStatus bool
+	var flagListFiles bool
+	var flagSearch bool
+	var flagInfo bool
+	var flagContents bool
+	var flagField bool
+	var flagExtract bool
+	var flagControl bool
 	flag.BoolVar(&flagBuild, "build", false, "Creates a debian archive")
+	flag.BoolVar(&flagBuildSpec, "build-spec", false, "Creates a debian archive from a YAML or TOML spec file")
 	flag.BoolVar(&flagInstall, "install", false, "Install a debian archive")
+	flag.BoolVar(&flagRemove, "remove", false, "Remove an installed package except its conffiles")
+	flag.BoolVar(&flagPurge, "purge", false, "Remove an installed package including its conffiles")
+	flag.BoolVar(&flagUnpack, "unpack", false, "Unpack a debian archive without configuring it")
+	flag.BoolVar(&flagConfigure, "configure", false, "Configure an unpacked package")
+	flag.BoolVar(&flagPending, "pending", false, "Process all pending packages")
+	flag.BoolVar(&flagPending, "a", false, "Process all pending packages (shorthand)")
+	flag.DurationVar(&dpkg.LockTimeout, "lock-timeout", 0, "Wait at most this duration (ex: 30s) for locks held by other processes")
+	flag.BoolVar(&dpkg.ForceConfOld, "force-confold", false, "Keep the modified conffiles when the package ships a new version")
+	flag.BoolVar(&dpkg.ForceConfNew, "force-confnew", false, "Install the new version of the modified conffiles")
+	flag.BoolVar(&dpkg.ForceConfDef, "force-confdef", false, "Choose the default action for the modified conffiles without prompting")
+	flag.BoolVar(&flagAudit, "audit", false, "Search for partially installed packages")
+	flag.BoolVar(&flagAudit, "C", false, "Search for partially installed packages (shorthand)")
+	flag.BoolVar(&flagVerify, "verify", false, "Check the files of installed packages against the database")
+	flag.BoolVar(&flagVerify, "V", false, "Check the files of installed packages against the database (shorthand)")
+	flag.BoolVar(&flagList, "list", false, "List the packages matching the patterns")
+	flag.BoolVar(&flagList, "l", false, "List the packages matching the patterns (shorthand)")
+	flag.BoolVar(&flagStatus, "status", false, "Show the status of packages")
+	flag.BoolVar(&flagStatus, "s", false, "Show the status of packages (shorthand)")
+	flag.BoolVar(&flagListFiles, "listfiles", false, "List the files installed by packages")
+	flag.BoolVar(&flagListFiles, "L", false, "List the files installed by packages (shorthand)")
+	flag.BoolVar(&flagSearch, "search", false, "Search the packages owning the files matching the patterns")
+	flag.BoolVar(&flagSearch, "S", false, "Search the packages owning the files matching the patterns (shorthand)")
+	flag.BoolVar(&flagInfo, "info", false, "Show information about a debian archive")
+	flag.BoolVar(&flagInfo, "I", false, "Show information about a debian archive (shorthand)")
+	flag.BoolVar(&flagContents, "contents", false, "List the contents of a debian archive")
+	flag.BoolVar(&flagContents, "c", false, "List the contents of a debian archive (shorthand)")
+	flag.BoolVar(&flagField, "field", false, "Display control field(s) of a debian archive")
+	flag.BoolVar(&flagField, "f", false, "Display control field(s) of a debian archive (shorthand)")
+	flag.BoolVar(&flagExtract, "extract", false, "Extract the files of a debian archive in a directory")
+	flag.BoolVar(&flagExtract, "x", false, "Extract the files of a debian archive in a directory (shorthand)")
+	flag.BoolVar(&flagControl, "control", false, "Extract the control files of a debian archive in a directory")
+	flag.BoolVar(&flagControl, "e", false, "Extract the control files of a debian archive in a directory (shorthand)")
+	flag.StringVar(&dpkg.Compression, "Z", "xz", "Compression type: xz, gzip, zstd or none (with --build and --build-spec)")
+	flag.IntVar(&dpkg.CompressionLevel, "z", -1, "Compression level between 0 and 9 (with --build and --build-spec)")
+	flag.BoolVar(&dpkg.RootOwnerGroup, "root-owner-group", false, "Package files as owned by root (with --build and --build-spec)")
+	flag.Func("owner", "Override the owner of a packaged file as path=user:group (with --build and --build-spec)", func(value string) error {
+		path, owner, err := dpkg.ParseOwner(value)
+		if err != nil {
+			return err
+		}
+		dpkg.OwnerMapping[path] = owner
+		return nil
+	})
 	flag.Parse()
 	args := flag.Args()
 
@@ -24,12 +85,100 @@ func main() {
 		directory := args[0]
 		dest := args[1]
 		dpkg.Build(directory, dest)
+	} else if flagBuildSpec {
+		if len(args) < 2 {
+			fmt.Printf("Missing 'spec' and/or 'dest' arguments\n")
+			os.Exit(1)
+		}
+		dpkg.BuildSpec(args[0], args[1])
+	} else if flagInfo {
+		if len(args) < 1 {
+			fmt.Printf("Missing package archive\n")
+			os.Exit(1)
+		}
+		dpkg.Info(args[0])
+	} else if flagContents {
+		if len(args) < 1 {
+			fmt.Printf("Missing package archive\n")
+			os.Exit(1)
+		}
+		dpkg.Contents(args[0])
+	} else if flagField {
+		if len(args) < 1 {
+			fmt.Printf("Missing package archive\n")
+			os.Exit(1)
+		}
+		dpkg.Field(args[0], args[1:])
+	} else if flagExtract {
+		if len(args) < 2 {
+			fmt.Printf("Missing 'archive' and/or 'directory' arguments\n")
+			os.Exit(1)
+		}
+		dpkg.Extract(args[0], args[1])
+	} else if flagControl {
+		if len(args) < 1 {
+			fmt.Printf("Missing package archive\n")
+			os.Exit(1)
+		}
+		directory := "DEBIAN"
+		if len(args) > 1 {
+			directory = args[1]
+		}
+		dpkg.ExtractControl(args[0], directory)
 	} else if flagInstall {
 		if len(args) < 1 {
 			fmt.Printf("Missing package archive(s)\n")
 			os.Exit(1)
 		}
-		dpkg.Install(args)
+		if err := dpkg.Install(args); err != nil {
+			os.Exit(1)
+		}
+	} else if flagUnpack {
+		if len(args) < 1 {
+			fmt.Printf("Missing package archive(s)\n")
+			os.Exit(1)
+		}
+		if err := dpkg.Unpack(args); err != nil {
+			os.Exit(1)
+		}
+	} else if flagConfigure {
+		if len(args) < 1 && !flagPending {
+			fmt.Printf("Missing package name(s)\n")
+			os.Exit(1)
+		}
+		if err := dpkg.Configure(args, flagPending); err != nil {
+			os.Exit(1)
+		}
+	} else if flagAudit {
+		dpkg.Audit()
+	} else if flagVerify {
+		dpkg.Verify(args)
+	} else if flagList {
+		dpkg.List(args)
+	} else if flagStatus {
+		if len(args) < 1 {
+			fmt.Printf("Missing package name(s)\n")
+			os.Exit(1)
+		}
+		dpkg.ShowStatus(args)
+	} else if flagListFiles {
+		if len(args) < 1 {
+			fmt.Printf("Missing package name(s)\n")
+			os.Exit(1)
+		}
+		dpkg.ListFiles(args)
+	} else if flagSearch {
+		if len(args) < 1 {
+			fmt.Printf("Missing pattern(s)\n")
+			os.Exit(1)
+		}
+		dpkg.Search(args)
+	} else if flagRemove || flagPurge {
+		if len(args) < 1 {
+			fmt.Printf("Missing package name(s)\n")
+			os.Exit(1)
+		}
+		dpkg.Remove(args, flagPurge)
 	}
 
 }
@@ -1,11 +1,32 @@
 module github.com/julien-sobczak/linux-packages-from-scratch
 
-go 1.15
+go 1.22
 
 require (
+	github.com/BurntSushi/toml v1.6.0
 	github.com/andreyvit/diff v0.0.0-20170406064948-c7f18ee00883
 	github.com/blakesmith/ar v0.0.0-20190502131153-809d4375e1fb
 	github.com/julien-sobczak/deb822 v0.0.0-20210507065407-ffdd354bd57b
+	github.com/klauspost/compress v1.18.0
 	github.com/ulikunitz/xz v0.5.10
 	golang.org/x/crypto v0.0.0-20210503195802-e9a32991a82e
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/kr/pretty v0.1.0 // indirect
+	github.com/kr/pty v1.1.1 // indirect
+	github.com/kr/text v0.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/sergi/go-diff v1.2.0 // indirect
+	github.com/stretchr/objx v0.1.0 // indirect
+	github.com/stretchr/testify v1.4.0 // indirect
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110 // indirect
+	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68 // indirect
+	golang.org/x/term v0.0.0-20201126162022-7de9c90e9dd1 // indirect
+	golang.org/x/text v0.3.3 // indirect
+	golang.org/x/tools v0.0.0-20180917221912-90fa682c2a6e // indirect
+	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
+	gopkg.in/yaml.v2 v2.2.4 // indirect
 )
@@ -3,7 +3,6 @@ package apt
 import (
 	"bytes"
 	"crypto/md5"
-	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -20,10 +19,22 @@ import (
 	"golang.org/x/crypto/openpgp/clearsign"
 )
 
+// client supports http:// and file:// URIs (ex: a local mirror)
+var client = newClient()
+
+func newClient() *http.Client {
+	transport := &http.Transport{}
+	transport.RegisterProtocol("file", http.NewFileTransport(http.Dir("/")))
+	return &http.Client{Transport: transport}
+}
+
 type pkgAcquire struct {
 	cacheFile *CacheFile
+	offline   bool // Only read the files already downloaded (see CacheFile.OpenLists)
 
 	pendingJobs int
+	queue       []Item // Items added but not yet dispatched to a worker
+	queueCond   *sync.Cond
 	jobs        chan Item
 	results     chan error
 	jobsMutex   sync.Mutex
@@ -52,10 +63,12 @@ func NewPkgAcquire(c *CacheFile) *pkgAcquire {
 		cacheFile:   c,
 		hit:         0,
 		pendingJobs: 0,
-		jobs:        make(chan Item, 1000),
+		jobs:        make(chan Item),
 		results:     make(chan error, 1000),
 	}
+	a.queueCond = sync.NewCond(&a.jobsMutex)
 
+	go a.dispatcher(a.jobs)
 	for w := 1; w <= 2; w++ {
 		go a.worker(w, a.jobs, a.results)
 	}
@@ -63,13 +76,33 @@ func NewPkgAcquire(c *CacheFile) *pkgAcquire {
 	return a
 }
 
+// Add queues an item to download. The queue is unbounded and Add never blocks
+// as items are added by workers themselves when a download triggers new ones.
 func (a *pkgAcquire) Add(item Item) {
 	a.jobsMutex.Lock()
-	a.jobs <- item
+	a.queue = append(a.queue, item)
 	a.pendingJobs++
+	a.queueCond.Signal()
 	a.jobsMutex.Unlock()
 }
 
+// dispatcher sends the queued items to the workers in order.
+func (a *pkgAcquire) dispatcher(jobs chan<- Item) {
+	for {
+		a.jobsMutex.Lock()
+		for len(a.queue) == 0 {
+			a.queueCond.Wait()
+		}
+		item := a.queue[0]
+		a.queue[0] = nil
+		a.queue = a.queue[1:]
+		a.jobsMutex.Unlock()
+
+		// Block without holding the lock until a worker is available
+		jobs <- item
+	}
+}
+
 /**
  * Run downloads all items that have been added to this
  * download process.
@@ -115,18 +148,40 @@ func (a *pkgAcquire) downloadItem(item Item) error {
 	uri := item.DownloadURI()
 
 	dest := item.DestFile(uri)
-	resp, err := http.Get(uri)
+
+	if a.offline {
+		if _, err := os.Stat(dest); err != nil {
+			return fmt.Errorf("missing file %s", dest)
+		}
+		return item.Done(a.cacheFile, a)
+	}
 
 	a.hitMutex.Lock()
 	a.hit++
 	hit := a.hit
 	a.hitMutex.Unlock()
 
+	if NoDownload {
+		// Reuse the file downloaded previously
+		if _, err := os.Stat(dest); err != nil {
+			fmt.Printf("Err:%d %v\n\tNot available without network access\n", hit, item)
+			return fmt.Errorf("failed to fetch %s: download is disabled", uri)
+		}
+		fmt.Printf("Hit:%d %v\n", hit, item)
+		return item.Done(a.cacheFile, a)
+	}
+
+	resp, err := client.Get(uri)
+
 	if err != nil {
 		fmt.Printf("Err:%d %v\n\t%s\n", hit, item, err)
 		return err
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Printf("Err:%d %v\n\t%s\n", hit, item, resp.Status)
+		return fmt.Errorf("failed to fetch %s: %s", uri, resp.Status)
+	}
 
 	// Create the file
 	err = os.MkdirAll(filepath.Dir(dest), 0755)
@@ -307,14 +362,18 @@ func (i *IndexItem) Done(c *CacheFile, a *pkgAcquire) error {
 	}
 
 	// Process content
-	s.indices = append(s.indices, &pkgIndexFile{
-		doc: doc,
-	})
+	index := &pkgIndexFile{
+		doc:          doc,
+		component:    i.component,
+		architecture: i.architecture,
+	}
+	s.indices = append(s.indices, index)
 
 	for _, paragraph := range doc.Paragraphs {
 		c.AddPackage(&Package{
 			doc:    paragraph,
 			source: s,
+			index:  index,
 		})
 	}
 
@@ -362,29 +421,22 @@ func (i *PackageItem) DestFile(uri string) string {
 
 func (i *PackageItem) Done(c *CacheFile, a *pkgAcquire) error {
 	// Check file integrity
-
-	// Calculate the checksum
-	f, err := os.Open(i.pkg.cacheFilepath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
-	h := sha256.New()
-	if _, err := io.Copy(h, f); err != nil {
-		return err
-	}
-
-	indexChecksum := i.pkg.doc.Value("SHA256")
-	effectiveChecksum := fmt.Sprintf("%x", h.Sum(nil))
-
-	if indexChecksum != effectiveChecksum {
+	if !i.Verify() {
 		return fmt.Errorf("invalid checksum for %s", i.pkg.cacheFilepath)
 	}
 
 	return nil
 }
 
+// Verify checks the checksum of the downloaded archive against the index file.
+func (i *PackageItem) Verify() bool {
+	checksum, err := fileSHA256(i.DestFile(i.DownloadURI()))
+	if err != nil {
+		return false
+	}
+	return checksum == i.pkg.doc.Value("SHA256")
+}
+
 func (i PackageItem) String() string {
 	// Ex: https://packages.grafana.com/oss/deb stable/main amd64 grafana amd64 7.5.5
 	pkg := i.pkg
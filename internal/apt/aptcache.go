@@ -0,0 +1,450 @@
+package apt
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/julien-sobczak/deb822"
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+)
+
+/*
+ * This file implements the read-only commands of apt-cache.
+ */
+
+const (
+	// Default priorities used by APT when no pinning is configured
+	sourcePriority = 500
+	statusPriority = 100
+)
+
+func Search(args []string, namesOnly bool, full bool) {
+	if len(args) < 1 {
+		fmt.Printf("E: You must give at least one search pattern\n")
+		os.Exit(1)
+	}
+
+	// Searches are case-insensitive like apt-cache
+	var patterns []*regexp.Regexp
+	for _, arg := range args {
+		pattern, err := regexp.Compile("(?i)" + arg)
+		if err != nil {
+			fmt.Printf("E: Regex compilation error - %s\n", err)
+			os.Exit(1)
+		}
+		patterns = append(patterns, pattern)
+	}
+
+	cache := &CacheFile{}
+	cache.OpenLists()
+
+	for _, pkg := range cache.Search(patterns, namesOnly) {
+		if full {
+			fmt.Println(formatPackage(pkg))
+		} else {
+			fmt.Printf("%s - %s\n", pkg.Name(), pkg.ShortDescription())
+		}
+	}
+}
+
+// Search returns the candidate packages matching all patterns, sorted by name.
+func (c *CacheFile) Search(patterns []*regexp.Regexp, namesOnly bool) []*Package {
+	var results []*Package
+	for _, pkg := range c.GetPackages() {
+		match := true
+		for _, pattern := range patterns {
+			if pattern.MatchString(pkg.Name()) {
+				continue
+			}
+			if !namesOnly && pattern.MatchString(pkg.doc.Value("Description")) {
+				continue
+			}
+			match = false
+			break
+		}
+		if match {
+			results = append(results, pkg)
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Name() < results[j].Name()
+	})
+	return results
+}
+
+func Show(args []string) {
+	if len(args) < 1 {
+		fmt.Printf("E: No packages found\n")
+		os.Exit(1)
+	}
+
+	cache := &CacheFile{}
+	cache.OpenLists()
+
+	for _, pkgName := range args {
+		versions := cache.GetPackageVersions(pkgName)
+		if len(versions) == 0 {
+			fmt.Printf("E: No packages found\n")
+			os.Exit(1)
+		}
+		for _, pkg := range versions {
+			fmt.Println(formatPackage(pkg))
+		}
+	}
+}
+
+func Policy(args []string) {
+	if len(args) < 1 {
+		fmt.Printf("E: No packages found\n")
+		os.Exit(1)
+	}
+
+	cache := &CacheFile{}
+	cache.OpenLists()
+
+	for _, pkgName := range args {
+		policy := cache.Policy(pkgName)
+		if policy == nil {
+			fmt.Printf("N: Unable to locate package %s\n", pkgName)
+			continue
+		}
+		fmt.Print(policy)
+	}
+}
+
+// PackagePolicy describes where the versions of a package come from.
+type PackagePolicy struct {
+	Name             string
+	InstalledVersion string
+	CandidateVersion string
+	Versions         []*VersionPolicy // Most recent first
+}
+
+type VersionPolicy struct {
+	Version  string
+	Priority int
+	Origins  []*VersionOrigin
+}
+
+type VersionOrigin struct {
+	Priority    int
+	Description string // Ex: http://deb.debian.org/debian buster/main amd64 Packages
+}
+
+// Policy returns the policy of a package or nil if the package is unknown.
+func (c *CacheFile) Policy(pkgName string) *PackagePolicy {
+	policy := &PackagePolicy{
+		Name: pkgName,
+	}
+
+	if state, ok := c.depCache.states[pkgName]; ok {
+		policy.InstalledVersion = state.CurrentVersion
+	}
+	if candidate := c.GetPackage(pkgName); candidate != nil {
+		policy.CandidateVersion = candidate.Version()
+	} else {
+		policy.CandidateVersion = policy.InstalledVersion
+	}
+
+	getVersion := func(version string) *VersionPolicy {
+		for _, v := range policy.Versions {
+			if v.Version == version {
+				return v
+			}
+		}
+		v := &VersionPolicy{
+			Version: version,
+		}
+		policy.Versions = append(policy.Versions, v)
+		return v
+	}
+
+	for _, pkg := range c.GetPackageVersions(pkgName) {
+		v := getVersion(pkg.Version())
+		v.Priority = sourcePriority
+		v.Origins = append(v.Origins, &VersionOrigin{
+			Priority:    sourcePriority,
+			Description: pkg.Origin(),
+		})
+	}
+	if policy.InstalledVersion != "" {
+		v := getVersion(policy.InstalledVersion)
+		if v.Priority < statusPriority {
+			v.Priority = statusPriority
+		}
+		v.Origins = append(v.Origins, &VersionOrigin{
+			Priority:    statusPriority,
+			Description: filepath.Join(dpkg.VarDir, "status"),
+		})
+	}
+
+	if len(policy.Versions) == 0 {
+		return nil
+	}
+	sort.SliceStable(policy.Versions, func(i, j int) bool {
+		return dpkg.CompareVersions(policy.Versions[i].Version, policy.Versions[j].Version) > 0
+	})
+	return policy
+}
+
+func (p PackagePolicy) String() string {
+	var sb strings.Builder
+	installed := p.InstalledVersion
+	if installed == "" {
+		installed = "(none)"
+	}
+	sb.WriteString(fmt.Sprintf("%s:\n", p.Name))
+	sb.WriteString(fmt.Sprintf("  Installed: %s\n", installed))
+	sb.WriteString(fmt.Sprintf("  Candidate: %s\n", p.CandidateVersion))
+	sb.WriteString("  Version table:\n")
+	for _, v := range p.Versions {
+		marker := "    "
+		if v.Version == p.InstalledVersion {
+			marker = "*** "
+		}
+		sb.WriteString(fmt.Sprintf(" %s%s %d\n", marker, v.Version, v.Priority))
+		for _, origin := range v.Origins {
+			sb.WriteString(fmt.Sprintf("        %d %s\n", origin.Priority, origin.Description))
+		}
+	}
+	return sb.String()
+}
+
+func formatPackage(pkg *Package) string {
+	formatter := deb822.NewFormatter()
+	formatter.SetFoldedFields("Description")
+	return formatter.Format(deb822.Document{
+		Paragraphs: []deb822.Paragraph{pkg.doc},
+	})
+}
+
+func Depends(args []string, recurse bool, installedOnly bool) {
+	if len(args) < 1 {
+		fmt.Printf("E: No packages found\n")
+		os.Exit(1)
+	}
+
+	cache := &CacheFile{}
+	cache.OpenLists()
+
+	graph := cache.DependencyGraph(args, false, recurse, installedOnly)
+	for _, node := range graph.Nodes {
+		fmt.Println(node)
+		for _, edge := range graph.Edges {
+			if edge.From != node {
+				continue
+			}
+			prefix := "  "
+			if edge.Alternative {
+				prefix = " |"
+			}
+			fmt.Printf("%s%s: %s\n", prefix, edge.Type, edge.Dependency())
+		}
+	}
+}
+
+func RDepends(args []string, recurse bool, installedOnly bool) {
+	if len(args) < 1 {
+		fmt.Printf("E: No packages found\n")
+		os.Exit(1)
+	}
+
+	cache := &CacheFile{}
+	cache.OpenLists()
+
+	graph := cache.DependencyGraph(args, true, recurse, installedOnly)
+	for _, node := range graph.Nodes {
+		fmt.Println(node)
+		fmt.Println("Reverse Depends:")
+		for _, edge := range graph.Edges {
+			if edge.To != node {
+				continue
+			}
+			prefix := "  "
+			if edge.Alternative {
+				prefix = " |"
+			}
+			fmt.Printf("%s%s\n", prefix, edge.From)
+		}
+	}
+}
+
+func Dotty(args []string, format string, installedOnly bool) {
+	if len(args) < 1 {
+		fmt.Printf("E: No packages found\n")
+		os.Exit(1)
+	}
+
+	cache := &CacheFile{}
+	cache.OpenLists()
+
+	graph := cache.DependencyGraph(args, false, true, installedOnly)
+	switch format {
+	case "dot":
+		fmt.Print(graph.DOT())
+	case "json":
+		out, err := json.MarshalIndent(graph, "", "  ")
+		if err != nil {
+			fmt.Printf("E: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	default:
+		fmt.Printf("E: Unknown format %s\n", format)
+		os.Exit(1)
+	}
+}
+
+// DependencyGraph represents the relationships between packages.
+type DependencyGraph struct {
+	Nodes []string          `json:"nodes"` // Package names in traversal order
+	Edges []*DependencyEdge `json:"edges"`
+}
+
+// DependencyEdge means the package From declares a relationship with the package To.
+type DependencyEdge struct {
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Type        string `json:"type"` // Ex: Depends
+	Relation    string `json:"relation,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Alternative bool   `json:"alternative,omitempty"` // True when followed by another alternative
+}
+
+func (e *DependencyEdge) Dependency() Dependency {
+	return Dependency{
+		Name:     e.To,
+		Relation: e.Relation,
+		Version:  e.Version,
+	}
+}
+
+// DependencyGraph traverses the relationships starting from the given packages.
+// When reverse is true, the packages declaring a relationship with the visited packages are traversed instead.
+// When installedOnly is true, only the installed versions and installed packages are considered.
+func (c *CacheFile) DependencyGraph(pkgNames []string, reverse bool, recurse bool, installedOnly bool) *DependencyGraph {
+	graph := &DependencyGraph{}
+
+	lookup := func(name string) *Package {
+		if installedOnly {
+			return c.GetInstalledPackage(name)
+		}
+		if pkg := c.GetPackage(name); pkg != nil {
+			return pkg
+		}
+		return c.GetInstalledPackage(name)
+	}
+
+	edgesFrom := func(pkg *Package) []*DependencyEdge {
+		var edges []*DependencyEdge
+		for _, relation := range pkg.Relations() {
+			var alternatives []Dependency
+			for _, dep := range relation.Alternatives {
+				if installedOnly && c.GetInstalledPackage(dep.Name) == nil {
+					continue
+				}
+				alternatives = append(alternatives, dep)
+			}
+			for i, dep := range alternatives {
+				edges = append(edges, &DependencyEdge{
+					From:        pkg.Name(),
+					To:          dep.Name,
+					Type:        relation.Type,
+					Relation:    dep.Relation,
+					Version:     dep.Version,
+					Alternative: i < len(alternatives)-1,
+				})
+			}
+		}
+		return edges
+	}
+
+	// Index reverse dependencies
+	reverseEdges := make(map[string][]*DependencyEdge)
+	if reverse {
+		pkgs := c.GetInstalledPackages()
+		if !installedOnly {
+			for _, pkg := range c.GetPackages() {
+				if c.GetInstalledPackage(pkg.Name()) == nil {
+					pkgs = append(pkgs, pkg)
+				}
+			}
+			sort.Slice(pkgs, func(i, j int) bool {
+				return pkgs[i].Name() < pkgs[j].Name()
+			})
+		}
+		for _, pkg := range pkgs {
+			for _, edge := range edgesFrom(lookup(pkg.Name())) {
+				reverseEdges[edge.To] = append(reverseEdges[edge.To], edge)
+			}
+		}
+	}
+
+	visited := make(map[string]bool)
+	queue := append([]string{}, pkgNames...)
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+		graph.Nodes = append(graph.Nodes, name)
+
+		var edges []*DependencyEdge
+		if reverse {
+			edges = reverseEdges[name]
+		} else if pkg := lookup(name); pkg != nil {
+			edges = edgesFrom(pkg)
+		}
+		for _, edge := range edges {
+			graph.Edges = append(graph.Edges, edge)
+			if recurse {
+				if reverse {
+					queue = append(queue, edge.From)
+				} else {
+					queue = append(queue, edge.To)
+				}
+			}
+		}
+	}
+
+	return graph
+}
+
+// DOT returns the graph using the Graphviz syntax.
+func (g *DependencyGraph) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("digraph packages {\n")
+	sb.WriteString("\tconcentrate=true;\n")
+	for _, node := range g.Nodes {
+		sb.WriteString(fmt.Sprintf("\t%q [shape=box];\n", node))
+	}
+	for _, edge := range g.Edges {
+		// Same conventions as apt-cache dotty
+		style := "solid"
+		color := "black"
+		switch edge.Type {
+		case "Pre-Depends":
+			color = "blue"
+		case "Recommends", "Suggests":
+			style = "dashed"
+		case "Conflicts":
+			color = "red"
+		case "Breaks":
+			style = "dashed"
+			color = "red"
+		}
+		if edge.Alternative {
+			color = "springgreen"
+		}
+		sb.WriteString(fmt.Sprintf("\t%q -> %q [label=%q,style=%s,color=%s];\n", edge.From, edge.To, edge.Type, style, color))
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
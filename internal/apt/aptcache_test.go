@@ -0,0 +1,387 @@
+package apt_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/diff"
+	"github.com/blakesmith/ar"
+	"github.com/julien-sobczak/deb822"
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/apt"
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+func TestSearchShowPolicy(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello-1.1-1/DEBIAN/control": []byte(`Package: hello
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"hello-2.1-1/DEBIAN/control": []byte(`Package: hello
+Version: 2.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"world-1.0-1/DEBIAN/control": []byte(`Package: world
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Print the world
+ Print hello to the whole world.
+`),
+
+		"/var/lib/dpkg/status": []byte(`Package: hello
+Status: install ok installed
+Architecture: all
+Version: 1.1-1
+`),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "hello-1.1-1", "hello-2.1-1", "world-1.0-1")
+
+	cache := &apt.CacheFile{}
+	cache.Open()
+
+	// Search
+	names := func(pkgs []*apt.Package) string {
+		var res []string
+		for _, pkg := range pkgs {
+			res = append(res, pkg.Name())
+		}
+		return strings.Join(res, " ")
+	}
+	hello := []*regexp.Regexp{regexp.MustCompile("(?i)hello")}
+	if actual := names(cache.Search(hello, false)); actual != "hello world" {
+		t.Errorf("Unexpected search results: %s", actual)
+	}
+	if actual := names(cache.Search(hello, true)); actual != "hello" {
+		t.Errorf("Unexpected search results with names only: %s", actual)
+	}
+
+	// Show
+	versions := cache.GetPackageVersions("hello")
+	if len(versions) != 2 || versions[0].Version() != "2.1-1" || versions[1].Version() != "1.1-1" {
+		t.Errorf("Unexpected versions for hello: %v", versions)
+	}
+
+	// Policy
+	policy := cache.Policy("hello")
+	expected := fmt.Sprintf(`hello:
+  Installed: 1.1-1
+  Candidate: 2.1-1
+  Version table:
+     2.1-1 500
+        500 file://%[1]s/repo buster/main amd64 Packages
+ *** 1.1-1 500
+        500 file://%[1]s/repo buster/main amd64 Packages
+        100 %[1]s/var/lib/dpkg/status
+`, testdir)
+	if policy == nil || policy.String() != expected {
+		t.Errorf("Unexpected policy:\n%v", policy)
+	}
+	if cache.Policy("unknown") != nil {
+		t.Errorf("Unexpected policy for an unknown package")
+	}
+}
+
+func TestInstallLocalArchive(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello-1.1-1/DEBIAN/control": []byte(`Package: hello
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"hello-1.1-1/usr/share/doc/hello/VERSION": []byte(`1.1-1`),
+		"hello-2.1-1/DEBIAN/control": []byte(`Package: hello
+Version: 2.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"hello-2.1-1/usr/share/doc/hello/VERSION": []byte(`2.1-1`),
+
+		"/var/lib/dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "hello-2.1-1")
+	if err := os.MkdirAll(filepath.Join(testdir, "/var/lib/dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The local archive is installed even if the repository contains a more recent version
+	archive := filepath.Join(testdir, "hello_1.1-1_all.deb")
+	dpkg.Build(filepath.Join(testdir, "hello-1.1-1"), archive)
+	apt.Install([]string{archive})
+	testutil.CheckFileContains(t, filepath.Join(testdir, "/usr/share/doc/hello/VERSION"), "1.1-1")
+}
+
+func TestDependencyGraph(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello/DEBIAN/control": []byte(`Package: hello
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: libhello (>= 1.0), cowsay | fortune
+Conflicts: goodbye
+Breaks: libhello (<< 1.0)
+Description: Say Hello
+`),
+		"libhello/DEBIAN/control": []byte(`Package: libhello
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Pre-Depends: libc6
+Description: Hello library
+`),
+
+		"/var/lib/dpkg/status": []byte(`Package: libc6
+Status: install ok installed
+Architecture: amd64
+Version: 2.28-10
+
+Package: cowsay
+Status: install ok installed
+Architecture: all
+Version: 3.03+dfsg2-6
+`),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "hello", "libhello")
+
+	cache := &apt.CacheFile{}
+	cache.Open()
+
+	graph := cache.DependencyGraph([]string{"hello"}, false, true, false)
+	expected := `digraph packages {
+	concentrate=true;
+	"hello" [shape=box];
+	"libhello" [shape=box];
+	"cowsay" [shape=box];
+	"fortune" [shape=box];
+	"goodbye" [shape=box];
+	"libc6" [shape=box];
+	"hello" -> "libhello" [label="Depends",style=solid,color=black];
+	"hello" -> "cowsay" [label="Depends",style=solid,color=springgreen];
+	"hello" -> "fortune" [label="Depends",style=solid,color=black];
+	"hello" -> "goodbye" [label="Conflicts",style=solid,color=red];
+	"hello" -> "libhello" [label="Breaks",style=dashed,color=red];
+	"libhello" -> "libc6" [label="Pre-Depends",style=solid,color=blue];
+}
+`
+	if actual := graph.DOT(); actual != expected {
+		t.Errorf("Unexpected graph:\n%v", diff.LineDiff(actual, expected))
+	}
+
+	// Without recursion, only direct dependencies are listed
+	graph = cache.DependencyGraph([]string{"hello"}, false, false, false)
+	if len(graph.Edges) != 5 {
+		t.Errorf("Expected 5 edges, got %d", len(graph.Edges))
+	}
+
+	// Reverse dependencies
+	graph = cache.DependencyGraph([]string{"libc6"}, true, true, false)
+	if actual := strings.Join(graph.Nodes, " "); actual != "libc6 libhello hello" {
+		t.Errorf("Unexpected reverse dependencies: %s", actual)
+	}
+	graph = cache.DependencyGraph([]string{"libc6"}, true, true, true)
+	if actual := strings.Join(graph.Nodes, " "); actual != "libc6" {
+		t.Errorf("Unexpected installed reverse dependencies: %s", actual)
+	}
+}
+
+func TestSearchOffline(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello/DEBIAN/control": []byte(`Package: hello
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"world/DEBIAN/control": []byte(`Package: world
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: hello
+Description: Print the world
+`),
+
+		"/var/lib/dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "hello", "world")
+
+	// Download the lists
+	cache := &apt.CacheFile{}
+	cache.Open()
+
+	// Read-only queries never access the repository
+	if err := os.RemoveAll(filepath.Join(testdir, "repo")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name     string
+		run      func()
+		expected string
+	}{
+		{"search", func() { apt.Search([]string{"hello"}, false, false) }, "hello - Say Hello\n"},
+		{"policy", func() { apt.Policy([]string{"world"}) }, "world:\n  Installed: (none)\n"},
+		{"rdepends", func() { apt.RDepends([]string{"hello"}, false, false) }, "hello\nReverse Depends:\n  world\n"},
+	} {
+		if actual := testutil.CaptureStdout(t, tt.run); !strings.HasPrefix(actual, tt.expected) {
+			t.Errorf("Unexpected %s output:\n%s", tt.name, actual)
+		}
+	}
+}
+
+/* Test Helpers */
+
+// populateRepository creates a signed repository under <testdir>/repo
+// containing the packages built from the given directories
+// and configures APT to use it.
+func populateRepository(t *testing.T, testdir string, pkgdirs ...string) {
+	repodir := filepath.Join(testdir, "repo")
+
+	// Build the archives
+	var index strings.Builder
+	for _, pkgdir := range pkgdirs {
+		filename := fmt.Sprintf("pool/main/%s.deb", pkgdir)
+		archivePath := filepath.Join(repodir, filename)
+		if err := os.MkdirAll(filepath.Dir(archivePath), 0755); err != nil {
+			t.Fatal(err)
+		}
+		dpkg.Build(filepath.Join(testdir, pkgdir), archivePath)
+
+		content, err := ioutil.ReadFile(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paragraph := readControl(t, archivePath)
+		paragraph.Order = append(paragraph.Order, "Filename", "Size", "SHA256")
+		paragraph.Values["Filename"] = filename
+		paragraph.Values["Size"] = fmt.Sprintf("%d", len(content))
+		paragraph.Values["SHA256"] = fmt.Sprintf("%x", sha256.Sum256(content))
+		formatter := deb822.NewFormatter()
+		formatter.SetFoldedFields("Description")
+		index.WriteString(formatter.Format(deb822.Document{Paragraphs: []deb822.Paragraph{paragraph}}))
+		index.WriteString("\n")
+	}
+	packages := xzCompress(t, []byte(index.String()))
+
+	// Sign the Release file
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var release bytes.Buffer
+	w, err := clearsign.Encode(&release, entity.PrivateKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprintf(w, "Origin: Test\nCodename: buster\nMD5Sum:\n %x %d main/binary-amd64/Packages.xz\n", md5.Sum(packages), len(packages))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var publicKey bytes.Buffer
+	if err := entity.Serialize(&publicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.PopulateTestDir(t, testdir, map[string][]byte{
+		"repo/dists/buster/InRelease":                             release.Bytes(),
+		"repo/dists/buster/main/binary-amd64/Packages.xz":         packages,
+		"/etc/apt/sources.list":                                   []byte(fmt.Sprintf("deb file://%s buster main\n", repodir)),
+		"/etc/apt/trusted.gpg.d/debian-archive-buster-stable.gpg": publicKey.Bytes(),
+		"/var/lib/apt/lists/lock":                                 []byte(``),
+		"/var/cache/apt/archives/lock":                            []byte(``),
+	})
+
+	dpkg.VarDir = filepath.Join(testdir, "/var/lib/dpkg/")
+	dpkg.RootDir = testdir
+	apt.EtcDir = filepath.Join(testdir, "/etc/apt")
+	apt.VarDir = filepath.Join(testdir, "/var/lib/apt")
+	apt.CacheDir = filepath.Join(testdir, "/var/cache/apt")
+	apt.AssumeYes = true
+}
+
+// readControl returns the control file of a Debian archive.
+func readControl(t *testing.T, archivePath string) deb822.Paragraph {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader := ar.NewReader(f)
+	if _, err := reader.Next(); err != nil { // debian-binary
+		t.Fatal(err)
+	}
+	header, err := reader.Next() // control.tar.xz
+	if err != nil {
+		t.Fatal(err)
+	}
+	decompressed, err := dpkg.Decompress(header.Name, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, decompressed); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := dpkg.ParseControl(nil, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Remove the Status field added by dpkg
+	paragraph := deb822.Paragraph{Values: make(map[string]string)}
+	for _, field := range pkg.Paragraph.Order {
+		if field == "Status" {
+			continue
+		}
+		paragraph.Order = append(paragraph.Order, field)
+		paragraph.Values[field] = pkg.Paragraph.Value(field)
+	}
+	return paragraph
+}
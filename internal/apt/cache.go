@@ -6,7 +6,10 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/julien-sobczak/deb822"
 	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
@@ -19,7 +22,9 @@ type CacheFile struct {
 }
 
 type pkgCache struct {
-	packages map[string]*Package
+	packages map[string]*Package   // Candidate version of every package
+	versions map[string][]*Package // All known versions of every package
+	mutex    sync.Mutex
 }
 
 type pkgDepCache struct {
@@ -46,7 +51,9 @@ type pkgSource struct {
 }
 
 func (s *pkgSource) EscapedURI() string {
-	return strings.ReplaceAll(strings.TrimPrefix(s.URI, "http://"), "/", "_")
+	// Ex: http://deb.debian.org/debian => deb.debian.org_debian
+	uri := regexp.MustCompile(`^\w+://`).ReplaceAllString(s.URI, "")
+	return strings.ReplaceAll(strings.Trim(uri, "/"), "/", "_")
 }
 
 func ParseSourceFile(content string) []*pkgSource {
@@ -80,6 +87,7 @@ func ParseSourceFile(content string) []*pkgSource {
 func (c *CacheFile) BuildCaches() {
 	c.cache = &pkgCache{
 		packages: make(map[string]*Package),
+		versions: make(map[string][]*Package),
 	}
 }
 
@@ -105,7 +113,7 @@ func (c *CacheFile) BuildDepCache() {
 	// Add state for package already installed
 	for _, pkg := range status.Paragraphs {
 		// The status file also contains packages that was partially installed or removed.
-		if !strings.Contains(pkg.Value("Status"), "installed") {
+		if !strings.HasSuffix(pkg.Value("Status"), " installed") {
 			continue
 		}
 		state, ok := states[pkg.Value("Package")]
@@ -114,6 +122,21 @@ func (c *CacheFile) BuildDepCache() {
 			states[pkg.Value("Package")] = state
 		}
 		state.CurrentVersion = pkg.Value("Version")
+		state.current = &Package{
+			doc: pkg,
+		}
+	}
+
+	// Read /var/lib/apt/extended_states
+	autoInstalled, err := ParseExtendedStates()
+	if err != nil {
+		fmt.Printf("E: The extended states file could not be parsed or opened.\n")
+		os.Exit(1)
+	}
+	for name := range autoInstalled {
+		if state, ok := states[name]; ok {
+			state.flagAuto = true
+		}
 	}
 
 	c.depCache = &pkgDepCache{
@@ -123,20 +146,8 @@ func (c *CacheFile) BuildDepCache() {
 }
 
 func ParseStatus() (*deb822.Document, error) {
-	statusPath := filepath.Join(dpkg.VarDir, "status")
-	f, err := os.Open(statusPath)
-	if err != nil {
-		return nil, err
-	}
-	parser, err := deb822.NewParser(f)
-	if err != nil {
-		return nil, err
-	}
-	statusContent, err := parser.Parse()
-	if err != nil {
-		return nil, err
-	}
-	return &statusContent, nil
+	// Include the pending changes of an interrupted dpkg run
+	return dpkg.LoadStatus()
 }
 
 func (c *CacheFile) BuildSourceList() {
@@ -180,7 +191,6 @@ func (c *CacheFile) Open() {
 	if c.sources == nil {
 		c.BuildCaches()
 		c.BuildSourceList()
-		c.BuildDepCache()
 	}
 
 	for _, source := range c.sources {
@@ -190,14 +200,59 @@ func (c *CacheFile) Open() {
 		acq.Add(NewMetaIndexItem(source))
 	}
 
+	lock := acquireLock(filepath.Join(VarDir, "lists", "lock"))
 	err := acq.Run()
+	lock.Release()
 	if err != nil {
 		fmt.Printf("E: Unable to fetch resources\n\t%s\n", err)
 		os.Exit(1)
 	}
+
+	// Determine candidate versions now that all index files are loaded
+	c.BuildDepCache()
+}
+
+// OpenLists loads the index files already downloaded under /var/lib/apt/lists/.
+// Unlike Open, the network is never used (like apt-cache).
+func (c *CacheFile) OpenLists() {
+	acq := NewPkgAcquire(c)
+	acq.offline = true
+
+	c.BuildCaches()
+	c.BuildSourceList()
+	for _, source := range c.sources {
+		if source.Type == "deb-src" {
+			continue // We are interested only in binary packages
+		}
+		acq.Add(NewMetaIndexItem(source))
+	}
+
+	if err := acq.Run(); err != nil {
+		fmt.Printf("W: Unable to read the package lists\n\t%s\n", strings.ReplaceAll(err.Error(), "\n", "\n\t"))
+	}
+
+	c.BuildDepCache()
+}
+
+// OpenLocal loads only the installed packages without downloading index files.
+func (c *CacheFile) OpenLocal() {
+	c.BuildCaches()
+	c.BuildDepCache()
 }
 
 func (c *CacheFile) AddPackage(p *Package) {
+	c.cache.mutex.Lock()
+	defer c.cache.mutex.Unlock()
+
+	c.cache.versions[p.Name()] = append(c.cache.versions[p.Name()], p)
+
+	// A local archive is always the candidate (ex: apt install ./hello_1.0-1_amd64.deb).
+	// Otherwise, the candidate is the most recent version.
+	if current, ok := c.cache.packages[p.Name()]; ok && !p.local {
+		if current.local || dpkg.CompareVersions(current.Version(), p.Version()) > 0 {
+			return
+		}
+	}
 	c.cache.packages[p.Name()] = p
 }
 
@@ -207,6 +262,16 @@ func (c *CacheFile) GetPackage(name string) *Package {
 	}
 	return nil
 }
+
+// GetPackageVersions returns all known versions of a package, the most recent first.
+func (c *CacheFile) GetPackageVersions(name string) []*Package {
+	versions := append([]*Package{}, c.cache.versions[name]...)
+	sort.SliceStable(versions, func(i, j int) bool {
+		return dpkg.CompareVersions(versions[i].Version(), versions[j].Version()) > 0
+	})
+	return versions
+}
+
 func (c *CacheFile) GetPackages() []*Package {
 	values := make([]*Package, 0, len(c.cache.packages))
 	for _, v := range c.cache.packages {
@@ -216,14 +281,28 @@ func (c *CacheFile) GetPackages() []*Package {
 }
 
 func (c *CacheFile) MarkForInstallation(pkgName string) {
+	c.markForInstallation(pkgName, false)
+}
+
+// markForInstallation marks a package and its dependencies recursively.
+// Packages pulled in as dependencies are flagged as automatically installed.
+func (c *CacheFile) markForInstallation(pkgName string, auto bool) {
 	// We will write a very basic version. We ignore most issues like versioning.
 	pkg := c.GetPackage(pkgName)
+	if pkg == nil && auto && c.GetInstalledPackage(pkgName) != nil {
+		// Dependency already satisfied by a package no longer available
+		return
+	}
 	if pkg == nil {
 		fmt.Printf("E: Unable to locate package %s\n", pkgName)
 		os.Exit(1)
 	}
 
 	state := c.GetState(pkg)
+	if !auto {
+		// Packages requested explicitly are always marked as manually installed
+		state.flagAuto = false
+	}
 	if state.Installed() || state.Install() {
 		// Already installed or marked for installation
 		return
@@ -232,10 +311,14 @@ func (c *CacheFile) MarkForInstallation(pkgName string) {
 	// Make sure to mark the package to prevent infinite cycles
 	state.CandidateVersion = pkg.Version()
 	state.flagInstall = true
+	state.flagAuto = auto
 
 	// Mark dependencies recursively
-	for _, dep := range pkg.Depends() {
-		c.MarkForInstallation(dep.Name)
+	for _, relation := range pkg.Relations() {
+		if relation.Type != "Pre-Depends" && relation.Type != "Depends" {
+			continue
+		}
+		c.markForInstallation(relation.Alternatives[0].Name, true)
 	}
 
 	// Add dependencies first in the installation sequence order
@@ -255,6 +338,28 @@ func (c *CacheFile) GetState(pkg *Package) *StateCache {
 	return state
 }
 
+// GetInstalledPackage returns the installed version of a package or nil if not installed.
+func (c *CacheFile) GetInstalledPackage(name string) *Package {
+	if state, ok := c.depCache.states[name]; ok {
+		return state.current
+	}
+	return nil
+}
+
+// GetInstalledPackages returns the installed packages sorted by name.
+func (c *CacheFile) GetInstalledPackages() []*Package {
+	var values []*Package
+	for _, state := range c.depCache.states {
+		if state.current != nil {
+			values = append(values, state.current)
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Name() < values[j].Name()
+	})
+	return values
+}
+
 func (c *CacheFile) InstCount() int {
 	count := 0
 	for _, state := range c.depCache.states {
@@ -269,16 +374,23 @@ type StateCache struct {
 	CandidateVersion string
 	CurrentVersion   string
 	flagInstall      bool
+	flagAuto         bool // Installed to satisfy dependencies
+
+	current *Package // Installed version as described in the status file
 }
 
 func (s *StateCache) Upgradable() bool {
-	return s.CurrentVersion != "" && s.CandidateVersion != "" && s.CurrentVersion != s.CandidateVersion
+	return s.CurrentVersion != "" && s.CandidateVersion != "" && dpkg.CompareVersions(s.CandidateVersion, s.CurrentVersion) > 0
 }
 
 func (s *StateCache) Install() bool {
 	return s.flagInstall
 }
 
+func (s *StateCache) Auto() bool {
+	return s.flagAuto
+}
+
 func (s *StateCache) Installed() bool {
 	return s.CurrentVersion != ""
 }
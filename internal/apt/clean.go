@@ -0,0 +1,94 @@
+package apt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Clean removes all archives from /var/cache/apt/archives/.
+func Clean() {
+	defer acquireLock(filepath.Join(CacheDir, "archives", "lock")).Release()
+
+	files, err := cachedArchives()
+	if err != nil {
+		fmt.Printf("E: Unable to read %s\n\t%s\n", filepath.Join(CacheDir, "archives"), err)
+		os.Exit(1)
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			fmt.Printf("E: Unable to remove %s\n\t%s\n", file, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// AutoClean removes the archives from /var/cache/apt/archives/
+// that can no longer be downloaded from the sources.
+func AutoClean() {
+	cache := &CacheFile{}
+	cache.Open()
+
+	defer acquireLock(filepath.Join(CacheDir, "archives", "lock")).Release()
+	removed, err := cache.AutoClean()
+	if err != nil {
+		fmt.Printf("E: %s\n", err)
+		os.Exit(1)
+	}
+	for _, file := range removed {
+		fmt.Printf("Del %s\n", filepath.Base(file))
+	}
+}
+
+// AutoClean removes the cached archives not referenced by index files and returns their paths.
+func (c *CacheFile) AutoClean() ([]string, error) {
+	known := make(map[string]bool)
+	for _, versions := range c.cache.versions {
+		for _, pkg := range versions {
+			if pkg.local {
+				continue
+			}
+			known[filepath.Base(pkg.doc.Value("Filename"))] = true
+		}
+	}
+
+	files, err := cachedArchives()
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for _, file := range files {
+		if known[filepath.Base(file)] {
+			continue
+		}
+		if err := os.Remove(file); err != nil {
+			return removed, err
+		}
+		removed = append(removed, file)
+	}
+	return removed, nil
+}
+
+// cachedArchives returns the .deb files present in /var/cache/apt/archives/ (including partial/).
+func cachedArchives() ([]string, error) {
+	var files []string
+	for _, dir := range []string{"archives", "archives/partial"} {
+		dirPath := filepath.Join(CacheDir, dir)
+		if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+			continue
+		}
+		entries, err := ioutil.ReadDir(dirPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".deb") {
+				continue
+			}
+			files = append(files, filepath.Join(dirPath, entry.Name()))
+		}
+	}
+	return files, nil
+}
@@ -4,4 +4,10 @@ var (
 	EtcDir   string = "/etc/apt/"
 	VarDir   string = "/var/lib/apt/"
 	CacheDir string = "/var/cache/apt/"
+
+	AssumeYes bool = false // Answer yes to all prompts (-y)
+	Simulate  bool = false // Print the actions without touching the system (-s)
+
+	DownloadOnly bool = false // Stop after downloading the archives (--download-only)
+	NoDownload   bool = false // Use only the files already downloaded (--no-download)
 )
@@ -0,0 +1,78 @@
+package apt_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/apt"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestDownloadOnly(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello/DEBIAN/control": []byte(`Package: hello
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"hello/usr/bin/hello": []byte(`#!/bin/sh
+echo "Hello"
+`),
+
+		"/var/lib/dpkg/status": []byte(``),
+		// An archive from a previous version
+		"/var/cache/apt/archives/hello_1.0-1_all.deb": []byte(`obsolete`),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "hello")
+	if err := os.MkdirAll(filepath.Join(testdir, "/var/lib/dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Download the archive only
+	apt.DownloadOnly = true
+	apt.Install([]string{"hello"})
+	apt.DownloadOnly = false
+	testutil.CheckFileExists(t, filepath.Join(testdir, "/var/cache/apt/archives/hello.deb"))
+	if _, err := os.Stat(filepath.Join(testdir, "/usr/bin/hello")); !os.IsNotExist(err) {
+		t.Errorf("Package hello must not be installed")
+	}
+
+	// Install without network access
+	if err := os.RemoveAll(filepath.Join(testdir, "repo")); err != nil {
+		t.Fatal(err)
+	}
+	apt.NoDownload = true
+	defer func() { apt.NoDownload = false }()
+	apt.Install([]string{"hello"})
+	testutil.CheckFileExists(t, filepath.Join(testdir, "/usr/bin/hello"))
+
+	// Remove archives no longer available
+	cache := &apt.CacheFile{}
+	cache.Open()
+	removed, err := cache.AutoClean()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || filepath.Base(removed[0]) != "hello_1.0-1_all.deb" {
+		t.Errorf("Unexpected removed archives: %v", removed)
+	}
+	testutil.CheckFileExists(t, filepath.Join(testdir, "/var/cache/apt/archives/hello.deb"))
+
+	// Remove all archives
+	apt.Clean()
+	if _, err := os.Stat(filepath.Join(testdir, "/var/cache/apt/archives/hello.deb")); !os.IsNotExist(err) {
+		t.Errorf("Archive hello.deb must be removed")
+	}
+	testutil.CheckFileExists(t, filepath.Join(testdir, "/var/cache/apt/archives/lock"))
+}
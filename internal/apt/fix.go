@@ -0,0 +1,138 @@
+package apt
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+)
+
+// FixBroken completes interrupted installations and installs missing dependencies.
+func FixBroken() {
+	if !Simulate {
+		defer lockFrontend()()
+	}
+
+	// Load the Cache
+	cache := &CacheFile{}
+	cache.Open()
+
+	db, err := dpkg.Load()
+	if err != nil {
+		fmt.Printf("E: Unable to read the dpkg database: %s\n", err)
+		os.Exit(1)
+	}
+
+	pending, err := cache.MarkBroken(db)
+	if err != nil {
+		fmt.Printf("E: %s\n", err)
+		os.Exit(1)
+	}
+
+	plan := cache.ComputePlan(nil, nil)
+	fmt.Print(plan)
+	if plan.Empty() && len(pending) == 0 {
+		return
+	}
+	if Simulate {
+		simulation, err := plan.Simulation(cache)
+		if err != nil {
+			fmt.Printf("E: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(simulation)
+		for _, name := range pending {
+			fmt.Printf("Conf %s\n", name)
+		}
+		return
+	}
+
+	if !plan.Empty() {
+		if !Confirm() {
+			fmt.Printf("Abort.\n")
+			os.Exit(1)
+		}
+		if err := InstallPackages(cache); err != nil {
+			fmt.Printf("E: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Finish the configuration of packages interrupted previously
+	if len(pending) > 0 {
+		if err := dpkg.Configure(nil, true); err != nil {
+			fmt.Printf("E: Sub-process dpkg returned an error (%v)\n", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// MarkBroken marks for installation the half-installed packages and the missing
+// dependencies of packages present on the system. The names of the packages
+// waiting for their configuration are returned.
+func (c *CacheFile) MarkBroken(db *dpkg.Directory) ([]string, error) {
+	// Packages whose files are present on disk
+	present := make(map[string]*Package)
+	var pending []string
+	for _, info := range db.Packages {
+		switch info.Status {
+		case "half-installed":
+			// Reinstall the package
+			if c.GetPackage(info.Name()) == nil {
+				return nil, fmt.Errorf("Unable to locate package %s to complete its installation", info.Name())
+			}
+			auto := false
+			if state, ok := c.depCache.states[info.Name()]; ok {
+				auto = state.Auto()
+			}
+			c.markForInstallation(info.Name(), auto)
+		case "unpacked", "half-configured":
+			pending = append(pending, info.Name())
+			present[info.Name()] = &Package{doc: info.Paragraph}
+		case "installed":
+			present[info.Name()] = &Package{doc: info.Paragraph}
+		}
+	}
+
+	var names []string
+	for name := range present {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, relation := range present[name].Relations() {
+			if relation.Type != "Pre-Depends" && relation.Type != "Depends" {
+				continue
+			}
+			satisfied := false
+			for _, dep := range relation.Alternatives {
+				if pkg, ok := present[dep.Name]; ok && dep.SatisfiedBy(pkg.Version()) {
+					satisfied = true
+				}
+				if pkg := c.GetPackage(dep.Name); pkg != nil && c.GetState(pkg).Install() && dep.SatisfiedBy(pkg.Version()) {
+					satisfied = true
+				}
+			}
+			if satisfied {
+				continue
+			}
+			resolved := false
+			for _, dep := range relation.Alternatives {
+				if pkg := c.GetPackage(dep.Name); pkg != nil && dep.SatisfiedBy(pkg.Version()) {
+					// An installed version too old is not replaced
+					c.markForInstallation(dep.Name, true)
+					if c.GetState(pkg).Install() {
+						resolved = true
+						break
+					}
+				}
+			}
+			if !resolved {
+				return nil, fmt.Errorf("Unmet dependencies: %s depends on %s but it is not installable", name, relation.Alternatives[0])
+			}
+		}
+	}
+	return pending, nil
+}
@@ -0,0 +1,166 @@
+package apt_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/apt"
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestFixBroken(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello/DEBIAN/control": []byte(`Package: hello
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: libhello
+Description: Say Hello
+`),
+		"hello/usr/bin/hello": []byte(`hello`),
+		"libhello/DEBIAN/control": []byte(`Package: libhello
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Hello library
+`),
+		"libhello/usr/lib/libhello.so": []byte(`library`),
+
+		"/var/lib/dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "hello", "libhello")
+	if err := os.MkdirAll(filepath.Join(testdir, "/var/lib/dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unpack hello without its dependency
+	dpkg.Unpack([]string{filepath.Join(testdir, "repo/pool/main/hello.deb")})
+
+	apt.FixBroken()
+	testutil.CheckFileExists(t, filepath.Join(testdir, "/usr/lib/libhello.so"))
+	testutil.CheckFileContains(t, filepath.Join(testdir, "/var/lib/dpkg/status"), `Package: hello
+Status: install ok installed
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Installed-Size: 3
+Depends: libhello
+Description: Say Hello
+
+Package: libhello
+Status: install ok installed
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Installed-Size: 3
+Description: Hello library
+`)
+}
+
+func TestFixBrokenOutdatedDependency(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"libhello/DEBIAN/control": []byte(`Package: libhello
+Version: 2.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Hello library
+`),
+
+		// hello requires a newer version of the installed libhello
+		"/var/lib/dpkg/status": []byte(`Package: hello
+Status: install ok unpacked
+Version: 2.1-1
+Architecture: all
+Depends: libhello (>= 2.0)
+
+Package: libhello
+Status: install ok installed
+Version: 1.0-1
+Architecture: all
+`),
+		"/var/lib/dpkg/info/hello.list":    []byte(``),
+		"/var/lib/dpkg/info/libhello.list": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "libhello")
+
+	cache := &apt.CacheFile{}
+	cache.Open()
+	db, err := dpkg.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.MarkBroken(db); err == nil || !strings.Contains(err.Error(), "hello depends on libhello (>= 2.0)") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestInstallPackagesError(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello/DEBIAN/control": []byte(`Package: hello
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: libhello
+Description: Say Hello
+`),
+		"hello/DEBIAN/postinst": []byte(`#!/bin/sh
+exit 1
+`),
+		"hello/usr/bin/hello": []byte(`hello`),
+		"libhello/DEBIAN/control": []byte(`Package: libhello
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Hello library
+`),
+		"libhello/usr/lib/libhello.so": []byte(`library`),
+
+		"/var/lib/dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	if err := os.Chmod(filepath.Join(testdir, "hello/DEBIAN/postinst"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	populateRepository(t, testdir, "hello", "libhello")
+	if err := os.MkdirAll(filepath.Join(testdir, "/var/lib/dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failure of dpkg is reported and the auto-installed packages are not recorded
+	cache := &apt.CacheFile{}
+	cache.Open()
+	cache.MarkForInstallation("hello")
+	if err := apt.InstallPackages(cache); err == nil || !strings.Contains(err.Error(), "hello") {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(testdir, "/var/lib/apt/extended_states")); !os.IsNotExist(err) {
+		t.Errorf("File extended_states must not be written")
+	}
+}
@@ -1,7 +1,7 @@
 package apt
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -9,7 +9,6 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/blakesmith/ar"
 	"github.com/julien-sobczak/deb822"
 	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
 )
@@ -20,6 +19,10 @@ func Install(args []string) {
 		pkgNames = append(pkgNames, args...)
 	}
 
+	if !Simulate {
+		defer lockFrontend()()
+	}
+
 	// Load the Cache
 	cache := &CacheFile{}
 	cache.Open()
@@ -29,6 +32,11 @@ func Install(args []string) {
 	for _, pkgName := range pkgNames {
 		if strings.HasSuffix(pkgName, ".deb") { // Archive not in cache
 			pkg, err := registerPackage(cache, pkgName)
+			var archiveErr *dpkg.ArchiveError
+			if errors.As(err, &archiveErr) {
+				fmt.Printf("E: Invalid archive %s\n\t%s\n", pkgName, err)
+				os.Exit(1)
+			}
 			if err != nil {
 				fmt.Printf("E: Unable to locate package %s\n", pkgName)
 				os.Exit(1)
@@ -73,6 +81,28 @@ func Install(args []string) {
 		fmt.Printf("Suggested packages:\n\t%s\n", strings.Join(suggests, " "))
 	}
 
+	// Print out the summary of the transaction
+	plan := cache.ComputePlan(nil, nil)
+	fmt.Print(plan)
+	if plan.Empty() {
+		return
+	}
+	if Simulate {
+		simulation, err := plan.Simulation(cache)
+		if err != nil {
+			fmt.Printf("E: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(simulation)
+		return
+	}
+
+	// Ask for confirmation when installing more than requested
+	if cache.InstCount() != len(pkgNames) && !Confirm() {
+		fmt.Printf("Abort.\n")
+		os.Exit(1)
+	}
+
 	err := InstallPackages(cache)
 	if err != nil {
 		fmt.Printf("E: %s\n", err)
@@ -82,11 +112,15 @@ func Install(args []string) {
 
 type pkgIndexFile struct {
 	doc deb822.Document // Content of the Packages file
+
+	component    string // Ex: main
+	architecture string // Ex: amd64
 }
 
 type Package struct {
 	doc    deb822.Paragraph
 	source *pkgSource
+	index  *pkgIndexFile
 
 	local         bool
 	localFilepath string
@@ -105,6 +139,23 @@ func (p *Package) Architecture() string {
 	return p.doc.Value("Architecture")
 }
 
+// ShortDescription returns the first line of the description.
+func (p *Package) ShortDescription() string {
+	return strings.SplitN(p.doc.Value("Description"), "\n", 2)[0]
+}
+
+// Origin returns where the package comes from.
+func (p *Package) Origin() string {
+	if p.local {
+		return p.localFilepath
+	}
+	if p.source == nil || p.index == nil {
+		return ""
+	}
+	// Ex: http://deb.debian.org/debian buster/main amd64 Packages
+	return fmt.Sprintf("%s %s/%s %s Packages", p.source.URI, p.source.Dist, p.index.component, p.index.architecture)
+}
+
 func (p *Package) Depends() []Dependency {
 	return ParseDependencies(p.doc.Value("Depends"))
 }
@@ -113,12 +164,70 @@ func (p *Package) Suggests() []Dependency {
 	return ParseDependencies(p.doc.Value("Suggests"))
 }
 
+// Conflicts returns the packages that cannot be installed at the same time (fields Conflicts and Breaks).
+func (p *Package) Conflicts() []Dependency {
+	return append(ParseDependencies(p.doc.Value("Conflicts")), ParseDependencies(p.doc.Value("Breaks"))...)
+}
+
+// dependencyFields lists the fields declaring relationships with other packages.
+var dependencyFields = []string{"Pre-Depends", "Depends", "Recommends", "Suggests", "Conflicts", "Breaks"}
+
+// Relation is a single entry in a dependency field with its alternatives (ex: "gpgv | gpgv2").
+type Relation struct {
+	Type         string // Ex: Depends
+	Alternatives []Dependency
+}
+
+// Relations returns all relationships declared by the package.
+func (p *Package) Relations() []Relation {
+	var relations []Relation
+	for _, field := range dependencyFields {
+		value := strings.TrimSpace(p.doc.Value(field))
+		if value == "" {
+			continue
+		}
+		for _, entry := range strings.Split(value, ",") {
+			relation := Relation{
+				Type: field,
+			}
+			for _, alternative := range strings.Split(entry, "|") {
+				relation.Alternatives = append(relation.Alternatives, ParseDependency(strings.TrimSpace(alternative)))
+			}
+			relations = append(relations, relation)
+		}
+	}
+	return relations
+}
+
 type Dependency struct {
 	Name     string
 	Version  string
 	Relation string
 }
 
+// SatisfiedBy returns true if the given version of the package satisfies the dependency.
+func (d Dependency) SatisfiedBy(version string) bool {
+	if version == "" {
+		return false
+	}
+	if d.Version == "" {
+		return true
+	}
+	cmp := dpkg.CompareVersions(version, d.Version)
+	switch d.Relation {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">>":
+		return cmp > 0
+	case "<<":
+		return cmp < 0
+	default: // =
+		return cmp == 0
+	}
+}
+
 func (d Dependency) String() string {
 	res := d.Name
 	if d.Version != "" {
@@ -146,7 +255,7 @@ func ParseDependency(value string) Dependency {
 
 	var dep Dependency
 
-	r := regexp.MustCompile(`^(?P<name>[\w\.-]+)(?:[:]\w+)?(?: [(](?P<relation>(?:>>|>=|=|<=|<<)) (?P<version>\S+)[)])?(?: [|].*)?$`)
+	r := regexp.MustCompile(`^(?P<name>[\w\.+-]+)(?:[:]\w+)?(?:\s*[(](?P<relation>(?:>>|>=|=|<=|<<))\s*(?P<version>[^\s)]+)[)])?(?:\s*\[[^\]]*\])?(?:\s*<[^>]*>)*(?: [|].*)?$`)
 	res := r.FindStringSubmatch(value)
 	names := r.SubexpNames()
 	for i, _ := range res {
@@ -192,23 +301,66 @@ func InstallPackages(cache *CacheFile) error {
 
 			pkg.cacheFilepath = destFilepath
 		} else {
-			acq.Add(NewPackageItem(pkg))
+			item := NewPackageItem(pkg)
+			if item.Verify() {
+				// Already present in /var/cache/apt/archives/
+				continue
+			}
+			acq.Add(item)
 		}
 	}
+	lock := acquireLock(filepath.Join(CacheDir, "archives", "lock"))
 	err := acq.Run()
+	lock.Release()
 	if err != nil {
 		return err
 	}
 
-	// Run dpkg
-	var archives []string
-	for _, pkgName := range cache.depCache.order {
-		pkg := cache.GetPackage(pkgName)
-		archives = append(archives, pkg.cacheFilepath)
+	if DownloadOnly {
+		fmt.Printf("Download complete and in download only mode\n")
+		return nil
+	}
+
+	// Run dpkg following the installation order
+	actions, err := cache.OrderInstallation()
+	if err != nil {
+		return err
+	}
+	for len(actions) > 0 {
+		// Group consecutive actions of the same type in a single dpkg call
+		n := 1
+		for n < len(actions) && actions[n].Type == actions[0].Type {
+			n++
+		}
+		var args []string
+		for _, action := range actions[:n] {
+			if action.Type == "unpack" {
+				args = append(args, action.Package.cacheFilepath)
+			} else {
+				args = append(args, action.Package.Name())
+			}
+		}
+		if actions[0].Type == "unpack" {
+			err = dpkg.Unpack(args)
+		} else {
+			// A package of a loop can be configured before the others are unpacked
+			for _, action := range actions[:n] {
+				for _, name := range action.Loop {
+					dpkg.DependencyLoop[name] = true
+				}
+			}
+			err = dpkg.Configure(args, false)
+			dpkg.DependencyLoop = make(map[string]bool)
+		}
+		if err != nil {
+			// Do not record the auto-installed packages of a failed installation
+			return fmt.Errorf("Sub-process dpkg returned an error (%v)", err)
+		}
+		actions = actions[n:]
 	}
-	dpkg.Install(archives)
 
-	return nil
+	// Remember the packages installed as dependencies
+	return cache.SaveExtendedStates()
 }
 
 func registerPackage(cache *CacheFile, archivePath string) (*Package, error) {
@@ -220,26 +372,17 @@ func registerPackage(cache *CacheFile, archivePath string) (*Package, error) {
 	}
 
 	// Read the debian archive file
-	f, err := os.Open(archivePath)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	reader := ar.NewReader(f)
-
-	// Skip debian-binary
-	_, err = reader.Next()
+	archive, err := dpkg.OpenArchive(archivePath)
 	if err != nil {
 		return nil, err
 	}
+	defer archive.Close()
 
 	// control.tar
-	_, err = reader.Next()
+	bufControl, err := archive.ReadControl()
 	if err != nil {
 		return nil, err
 	}
-	var bufControl bytes.Buffer
-	io.Copy(&bufControl, reader)
 
 	pkgInfo, err := dpkg.ParseControl(db, bufControl)
 	if err != nil {
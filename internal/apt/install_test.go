@@ -92,6 +92,7 @@ deb-src http://deb.debian.org/debian buster main
 	apt.EtcDir = filepath.Join(testdir, "/etc/apt")
 	apt.VarDir = filepath.Join(testdir, "/var/lib/apt")
 	apt.CacheDir = filepath.Join(testdir, "/var/cache/apt")
+	apt.AssumeYes = true
 	apt.Install([]string{testArchive})
 
 	// Check that the APT cache has been uploaded
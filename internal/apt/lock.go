@@ -0,0 +1,36 @@
+package apt
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+)
+
+// acquireLock locks a file (ex: /var/lib/apt/lists/lock) or exits if another process holds it.
+func acquireLock(path string) *dpkg.Lock {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		fmt.Printf("E: Unable to create directory %s\n\t%s\n", filepath.Dir(path), err)
+		os.Exit(1)
+	}
+	lock, err := dpkg.AcquireLock(path)
+	if err != nil {
+		fmt.Printf("E: %s\n", err)
+		fmt.Printf("E: Unable to acquire the lock %s, is another process using it?\n", path)
+		os.Exit(1)
+	}
+	return lock
+}
+
+// lockFrontend locks the dpkg frontend lock for the whole transaction.
+// dpkg is informed the lock is already held. The returned function releases the lock.
+func lockFrontend() func() {
+	lock := acquireLock(filepath.Join(dpkg.VarDir, "lock-frontend"))
+	frontendLocked := dpkg.FrontendLocked
+	dpkg.FrontendLocked = true
+	return func() {
+		dpkg.FrontendLocked = frontendLocked
+		lock.Release()
+	}
+}
@@ -0,0 +1,146 @@
+package apt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/julien-sobczak/deb822"
+)
+
+/*
+ * APT remembers the packages installed automatically to satisfy dependencies
+ * in the file /var/lib/apt/extended_states:
+ *
+ *     Package: libhello
+ *     Architecture: amd64
+ *     Auto-Installed: 1
+ *
+ * These packages can be removed safely when no other package depends on them.
+ */
+
+// ParseExtendedStates returns the architecture of the packages marked as automatically installed.
+func ParseExtendedStates() (map[string]string, error) {
+	autoInstalled := make(map[string]string)
+
+	path := filepath.Join(VarDir, "extended_states")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return autoInstalled, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	parser, err := deb822.NewParser(f)
+	if err != nil {
+		return nil, err
+	}
+	doc, err := parser.Parse()
+	if err != nil {
+		return nil, err
+	}
+	for _, paragraph := range doc.Paragraphs {
+		if paragraph.Value("Auto-Installed") == "1" {
+			autoInstalled[paragraph.Value("Package")] = paragraph.Value("Architecture")
+		}
+	}
+	return autoInstalled, nil
+}
+
+// SaveExtendedStates writes the list of packages marked as automatically installed.
+func (c *CacheFile) SaveExtendedStates() error {
+	var names []string
+	for name, state := range c.depCache.states {
+		if state.Auto() && (state.Installed() || state.Install()) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	doc := deb822.Document{}
+	for _, name := range names {
+		var arch string
+		if pkg := c.GetInstalledPackage(name); pkg != nil {
+			arch = pkg.Architecture()
+		} else if pkg := c.GetPackage(name); pkg != nil {
+			arch = pkg.Architecture()
+		}
+		doc.Paragraphs = append(doc.Paragraphs, deb822.Paragraph{
+			Order: []string{"Package", "Architecture", "Auto-Installed"},
+			Values: map[string]string{
+				"Package":        name,
+				"Architecture":   arch,
+				"Auto-Installed": "1",
+			},
+		})
+	}
+
+	if err := os.MkdirAll(VarDir, 0755); err != nil {
+		return err
+	}
+	formatter := deb822.NewFormatter()
+	return ioutil.WriteFile(filepath.Join(VarDir, "extended_states"), []byte(formatter.Format(doc)), 0644)
+}
+
+// SetAuto changes the flag automatically installed of an installed package.
+func (c *CacheFile) SetAuto(pkgName string, auto bool) error {
+	if c.GetInstalledPackage(pkgName) == nil {
+		return fmt.Errorf("package %s is not installed", pkgName)
+	}
+	c.depCache.states[pkgName].flagAuto = auto
+	return nil
+}
+
+// ShowAuto returns the installed packages whose flag automatically installed equals auto.
+func (c *CacheFile) ShowAuto(auto bool) []string {
+	var names []string
+	for _, pkg := range c.GetInstalledPackages() {
+		if c.depCache.states[pkg.Name()].Auto() == auto {
+			names = append(names, pkg.Name())
+		}
+	}
+	return names
+}
+
+func Mark(action string, args []string) {
+	if action == "auto" || action == "manual" {
+		// Like the other writers of extended_states
+		defer lockFrontend()()
+	}
+
+	cache := &CacheFile{}
+	cache.OpenLocal()
+
+	switch action {
+	case "auto", "manual":
+		if len(args) < 1 {
+			fmt.Printf("E: No packages found\n")
+			os.Exit(1)
+		}
+		for _, pkgName := range args {
+			if err := cache.SetAuto(pkgName, action == "auto"); err != nil {
+				fmt.Printf("E: Unable to locate package %s\n", pkgName)
+				os.Exit(1)
+			}
+			if action == "auto" {
+				fmt.Printf("%s set to automatically installed.\n", pkgName)
+			} else {
+				fmt.Printf("%s set to manually installed.\n", pkgName)
+			}
+		}
+		if err := cache.SaveExtendedStates(); err != nil {
+			fmt.Printf("E: Unable to write extended states\n\t%s\n", err)
+			os.Exit(1)
+		}
+	case "showauto", "showmanual":
+		for _, name := range cache.ShowAuto(action == "showauto") {
+			fmt.Println(name)
+		}
+	default:
+		fmt.Printf("E: Invalid operation %s\n", action)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,726 @@
+package apt
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/julien-sobczak/deb822"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+/*
+ * A mirror is a partial copy of one or more Debian repositories.
+ * The implementation reuses the pkgAcquire machinery used by apt to download
+ * the Release files, the Packages index files, and the .deb archives
+ * referenced by them. The resulting tree can be used as a file:// source:
+ *
+ *     deb file:///srv/mirror/mirror/deb.debian.org/debian buster main
+ *
+ * The config file uses a syntax inspired by apt-mirror:
+ *
+ *     set base_path     /srv/mirror
+ *     set architectures amd64 all
+ *     set keyring       /etc/apt/trusted.gpg.d/debian-archive-buster-stable.gpg
+ *     set signing_key   /srv/mirror/signing-key.gpg
+ *     deb http://deb.debian.org/debian buster main contrib
+ *     include ^hello$
+ *     exclude ^linux-image-
+ *
+ * Without filters, the Release files and the index files are mirrored as-is.
+ * With filters, the index files are rewritten to list only the mirrored packages
+ * and a new Release file is generated with their checksums. The upstream
+ * signatures are no longer valid and the Release file is signed using the
+ * configured signing key instead (InRelease and Release.gpg are removed otherwise).
+ */
+
+type MirrorConfig struct {
+	BasePath      string
+	Architectures []string
+	Keyring       string // Public key used to check InRelease signatures (optional)
+	SigningKey    string // Private key used to sign the generated Release files (optional)
+	Sources       []*MirrorSource
+	Includes      []*regexp.Regexp // Package names to mirror (all if empty)
+	Excludes      []*regexp.Regexp // Package names to ignore
+}
+
+type MirrorSource struct {
+	URI        string
+	Dist       string
+	Components []string
+}
+
+// Dir returns the directory containing the copy of the repository.
+func (s *MirrorSource) Dir(basePath string) string {
+	// Ex: http://deb.debian.org/debian => /srv/mirror/mirror/deb.debian.org/debian
+	path := regexp.MustCompile(`^\w+://`).ReplaceAllString(s.URI, "")
+	return filepath.Join(basePath, "mirror", filepath.FromSlash(path))
+}
+
+func ParseMirrorConfig(content string) (*MirrorConfig, error) {
+	config := &MirrorConfig{
+		Architectures: []string{"amd64"},
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			// Ignore blank lines and comments
+			continue
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "set":
+			if len(fields) < 3 {
+				return nil, fmt.Errorf("line %d: missing value for option", lineNumber)
+			}
+			switch fields[1] {
+			case "base_path":
+				config.BasePath = fields[2]
+			case "architectures":
+				config.Architectures = fields[2:]
+			case "keyring":
+				config.Keyring = fields[2]
+			case "signing_key":
+				config.SigningKey = fields[2]
+			default:
+				return nil, fmt.Errorf("line %d: unknown option %s", lineNumber, fields[1])
+			}
+		case "deb":
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("line %d: expected 'deb <uri> <dist> <component>...'", lineNumber)
+			}
+			config.Sources = append(config.Sources, &MirrorSource{
+				URI:        strings.TrimSuffix(fields[1], "/"),
+				Dist:       fields[2],
+				Components: fields[3:],
+			})
+		case "include", "exclude":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("line %d: expected '%s <regex>'", lineNumber, fields[0])
+			}
+			r, err := regexp.Compile(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: invalid regex: %v", lineNumber, err)
+			}
+			if fields[0] == "include" {
+				config.Includes = append(config.Includes, r)
+			} else {
+				config.Excludes = append(config.Excludes, r)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unknown directive %s", lineNumber, fields[0])
+		}
+	}
+
+	if config.BasePath == "" {
+		return nil, fmt.Errorf("missing option base_path")
+	}
+	if len(config.Sources) == 0 {
+		return nil, fmt.Errorf("no source to mirror")
+	}
+	return config, nil
+}
+
+// Filtered returns true if only some packages are mirrored.
+func (c *MirrorConfig) Filtered() bool {
+	return len(c.Includes) > 0 || len(c.Excludes) > 0
+}
+
+// Accept returns true if the package must be mirrored.
+func (c *MirrorConfig) Accept(pkgName string) bool {
+	for _, r := range c.Excludes {
+		if r.MatchString(pkgName) {
+			return false
+		}
+	}
+	if len(c.Includes) == 0 {
+		return true
+	}
+	for _, r := range c.Includes {
+		if r.MatchString(pkgName) {
+			return true
+		}
+	}
+	return false
+}
+
+type pkgMirror struct {
+	config *MirrorConfig
+
+	// Files (absolute paths) present in the mirror after the synchronization.
+	referenced      map[string]bool
+	referencedMutex sync.Mutex
+
+	// Release files of the sources, with the checksums of the rewritten index files.
+	releases      map[*MirrorSource]*mirrorRelease
+	releasesMutex sync.Mutex
+}
+
+type mirrorRelease struct {
+	doc     deb822.Paragraph  // Upstream Release file
+	entries map[string][]byte // Rewritten index files. Ex: main/binary-amd64/Packages.xz => content
+}
+
+func NewPkgMirror(config *MirrorConfig) *pkgMirror {
+	return &pkgMirror{
+		config:     config,
+		referenced: make(map[string]bool),
+		releases:   make(map[*MirrorSource]*mirrorRelease),
+	}
+}
+
+func Mirror(configPath string) {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		fmt.Printf("E: Unable to read mirror config %s\n\t%s\n", configPath, err)
+		os.Exit(1)
+	}
+	config, err := ParseMirrorConfig(string(content))
+	if err != nil {
+		fmt.Printf("E: Malformed mirror config %s\n\t%s\n", configPath, err)
+		os.Exit(1)
+	}
+
+	m := NewPkgMirror(config)
+	if err := m.Run(); err != nil {
+		fmt.Printf("E: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// Run downloads the missing files and removes the ones no longer referenced.
+func (m *pkgMirror) Run() error {
+	acq := NewPkgAcquire(nil)
+	for _, source := range m.config.Sources {
+		acq.Add(NewMirrorReleaseItem(m, source))
+	}
+	if err := acq.Run(); err != nil {
+		return fmt.Errorf("unable to mirror repositories\n\t%s", err)
+	}
+
+	for _, source := range m.config.Sources {
+		var err error
+		if m.config.Filtered() {
+			err = m.writeRelease(source)
+		} else {
+			err = m.verifyRelease(source)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	removed, err := m.Clean()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d files mirrored, %d obsolete files removed.\n", len(m.referenced), removed)
+	return nil
+}
+
+func (m *pkgMirror) reference(path string) {
+	m.referencedMutex.Lock()
+	m.referenced[path] = true
+	m.referencedMutex.Unlock()
+}
+
+func (m *pkgMirror) release(source *MirrorSource) *mirrorRelease {
+	m.releasesMutex.Lock()
+	defer m.releasesMutex.Unlock()
+	release, ok := m.releases[source]
+	if !ok {
+		release = &mirrorRelease{
+			entries: make(map[string][]byte),
+		}
+		m.releases[source] = release
+	}
+	return release
+}
+
+// verifyRelease checks the detached signature Release.gpg of the file Release.
+func (m *pkgMirror) verifyRelease(source *MirrorSource) error {
+	if m.config.Keyring == "" {
+		// Already reported when downloading InRelease
+		return nil
+	}
+	distDir := filepath.Join(source.Dir(m.config.BasePath), "dists", source.Dist)
+	release, err := os.Open(filepath.Join(distDir, "Release"))
+	if err != nil {
+		return err
+	}
+	defer release.Close()
+	signature, err := os.Open(filepath.Join(distDir, "Release.gpg"))
+	if err != nil {
+		return err
+	}
+	defer signature.Close()
+	keyring, err := readKeyRing(m.config.Keyring)
+	if err != nil {
+		return err
+	}
+	if _, err := openpgp.CheckArmoredDetachedSignature(keyring, release, signature); err != nil {
+		return fmt.Errorf("the following signatures couldn't be verified: %s\n%v", signature.Name(), err)
+	}
+	return nil
+}
+
+// writeRelease generates the Release file listing the rewritten index files.
+func (m *pkgMirror) writeRelease(source *MirrorSource) error {
+	release := m.release(source)
+	distDir := filepath.Join(source.Dir(m.config.BasePath), "dists", source.Dist)
+
+	// Keep the upstream fields except the checksums
+	doc := deb822.Paragraph{
+		Values: make(map[string]string),
+	}
+	for _, field := range release.doc.Order {
+		switch field {
+		case "MD5Sum", "SHA1", "SHA256", "SHA512":
+			continue
+		}
+		doc.Order = append(doc.Order, field)
+		doc.Values[field] = release.doc.Value(field)
+	}
+	var entryNames []string
+	for entryName := range release.entries {
+		entryNames = append(entryNames, entryName)
+	}
+	sort.Strings(entryNames)
+	var md5sums, sha256sums []string
+	for _, entryName := range entryNames {
+		content := release.entries[entryName]
+		// Ex: 0007f0860158f774977132f7c8dd3301  3919376 main/binary-amd64/Packages.xz
+		md5sums = append(md5sums, fmt.Sprintf("%x %8d %s", md5.Sum(content), len(content), entryName))
+		sha256sums = append(sha256sums, fmt.Sprintf("%x %8d %s", sha256.Sum256(content), len(content), entryName))
+	}
+	doc.Order = append(doc.Order, "MD5Sum", "SHA256")
+	doc.Values["MD5Sum"] = strings.Join(md5sums, "\n")
+	doc.Values["SHA256"] = strings.Join(sha256sums, "\n")
+
+	formatter := deb822.NewFormatter()
+	formatter.SetMultilineFields("MD5Sum", "SHA256")
+	content := formatter.Format(deb822.Document{Paragraphs: []deb822.Paragraph{doc}})
+	if err := os.WriteFile(filepath.Join(distDir, "Release"), []byte(content), 0644); err != nil {
+		return err
+	}
+	m.reference(filepath.Join(distDir, "Release"))
+
+	inRelease := filepath.Join(distDir, "InRelease")
+	releaseGPG := filepath.Join(distDir, "Release.gpg")
+	if m.config.SigningKey == "" {
+		fmt.Printf("W: The Release file of %s %s is not signed (no signing key)\n", source.URI, source.Dist)
+		for _, path := range []string{inRelease, releaseGPG} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		return nil
+	}
+
+	keyring, err := readKeyRing(m.config.SigningKey)
+	if err != nil {
+		return err
+	}
+	if len(keyring) == 0 || keyring[0].PrivateKey == nil {
+		return fmt.Errorf("missing private key in %s", m.config.SigningKey)
+	}
+	signer := keyring[0]
+
+	var clearsigned bytes.Buffer
+	w, err := clearsign.Encode(&clearsigned, signer.PrivateKey, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(inRelease, clearsigned.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, signer, strings.NewReader(content), nil); err != nil {
+		return err
+	}
+	if err := os.WriteFile(releaseGPG, signature.Bytes(), 0644); err != nil {
+		return err
+	}
+	m.reference(releaseGPG)
+	return nil
+}
+
+// Clean removes the files under pool/ and dists/ that are no longer referenced.
+// Ex: the index files of an architecture no longer mirrored.
+func (m *pkgMirror) Clean() (int, error) {
+	removed := 0
+	cleaned := make(map[string]bool)
+	for _, source := range m.config.Sources {
+		for _, dir := range []string{"pool", "dists"} {
+			dirPath := filepath.Join(source.Dir(m.config.BasePath), dir)
+			if cleaned[dirPath] {
+				// Sources of the same repository share the same directories
+				continue
+			}
+			cleaned[dirPath] = true
+			if _, err := os.Stat(dirPath); os.IsNotExist(err) {
+				continue
+			}
+			err := filepath.Walk(dirPath, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if info.IsDir() || m.referenced[path] {
+					return nil
+				}
+				fmt.Printf("Del: %s\n", path)
+				removed++
+				return os.Remove(path)
+			})
+			if err != nil {
+				return removed, err
+			}
+		}
+	}
+	return removed, nil
+}
+
+/*
+ * Release files are checked using the configured keyring.
+ * Only the SHA256 section is used to check the integrity of index files.
+ */
+
+type MirrorReleaseItem struct { // InRelease
+	mirror *pkgMirror
+	source *MirrorSource
+}
+
+func NewMirrorReleaseItem(mirror *pkgMirror, source *MirrorSource) *MirrorReleaseItem {
+	return &MirrorReleaseItem{
+		mirror: mirror,
+		source: source,
+	}
+}
+
+func (i *MirrorReleaseItem) DownloadURI() string {
+	// Ex: http://deb.debian.org/debian/dists/buster/InRelease
+	return i.source.URI + "/dists/" + i.source.Dist + "/InRelease"
+}
+
+func (i *MirrorReleaseItem) DestFile(uri string) string {
+	// Ex: /srv/mirror/mirror/deb.debian.org/debian/dists/buster/InRelease
+	return filepath.Join(i.source.Dir(i.mirror.config.BasePath), "dists", i.source.Dist, "InRelease")
+}
+
+func (i *MirrorReleaseItem) Done(c *CacheFile, acq *pkgAcquire) error {
+	path := i.DestFile(i.DownloadURI())
+	i.mirror.reference(path)
+
+	var content []byte
+	var err error
+	if i.mirror.config.Keyring != "" {
+		content, err = gpgDecode(path, i.mirror.config.Keyring)
+		if err != nil {
+			return fmt.Errorf("the following signatures couldn't be verified: %s\n%v", path, err)
+		}
+	} else {
+		fmt.Printf("W: The Release file of %s %s is not verified (no keyring)\n", i.source.URI, i.source.Dist)
+		content, err = os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+	}
+
+	parser, err := deb822.NewParser(bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("malformed Release file: %v", err)
+	}
+	doc, err := parser.Parse()
+	if err != nil {
+		return fmt.Errorf("malformed Release file: %v", err)
+	}
+	if len(doc.Paragraphs) == 0 {
+		return fmt.Errorf("malformed Release file: %s", path)
+	}
+	i.mirror.release(i.source).doc = doc.Paragraphs[0]
+
+	if !i.mirror.config.Filtered() {
+		// Mirror the other variants of the Release file as-is
+		acq.Add(NewMirrorFileItem(i.mirror, i.source, "Release"))
+		acq.Add(NewMirrorFileItem(i.mirror, i.source, "Release.gpg"))
+	}
+
+	entries := make(map[string]string)
+	for _, entry := range strings.Split(doc.Paragraphs[0].Value("SHA256"), "\n") {
+		// Ex: 1de5d4b3c1e2e1b3ab8b3e5a4c0ff3e8b1ef9cbfd25e2a4e7ed6c7c1c8b0d4a1    57365 contrib/Contents-all.gz
+		fields := strings.Fields(entry)
+		if len(fields) != 3 {
+			continue
+		}
+		entries[fields[2]] = fields[0]
+	}
+
+	for _, component := range i.source.Components {
+		for _, arch := range i.mirror.config.Architectures {
+			entryName := fmt.Sprintf("%s/binary-%s/Packages.xz", component, arch)
+			checksum, ok := entries[entryName]
+			if !ok {
+				return fmt.Errorf("missing entry %s in Release file %s", entryName, path)
+			}
+			acq.Add(NewMirrorIndexItem(i.mirror, i.source, entryName, checksum))
+		}
+	}
+
+	return nil
+}
+
+func (i MirrorReleaseItem) String() string {
+	// Ex: http://deb.debian.org/debian buster InRelease
+	return fmt.Sprintf("%s %s InRelease", i.source.URI, i.source.Dist)
+}
+
+type MirrorFileItem struct { // Release, Release.gpg
+	mirror *pkgMirror
+	source *MirrorSource
+	name   string
+}
+
+func NewMirrorFileItem(mirror *pkgMirror, source *MirrorSource, name string) *MirrorFileItem {
+	return &MirrorFileItem{
+		mirror: mirror,
+		source: source,
+		name:   name,
+	}
+}
+
+func (i *MirrorFileItem) DownloadURI() string {
+	// Ex: http://deb.debian.org/debian/dists/buster/Release.gpg
+	return i.source.URI + "/dists/" + i.source.Dist + "/" + i.name
+}
+
+func (i *MirrorFileItem) DestFile(uri string) string {
+	// Ex: /srv/mirror/mirror/deb.debian.org/debian/dists/buster/Release.gpg
+	return filepath.Join(i.source.Dir(i.mirror.config.BasePath), "dists", i.source.Dist, i.name)
+}
+
+func (i *MirrorFileItem) Done(c *CacheFile, acq *pkgAcquire) error {
+	// The signature is checked once all files have been downloaded
+	i.mirror.reference(i.DestFile(i.DownloadURI()))
+	return nil
+}
+
+func (i MirrorFileItem) String() string {
+	// Ex: http://deb.debian.org/debian buster Release.gpg
+	return fmt.Sprintf("%s %s %s", i.source.URI, i.source.Dist, i.name)
+}
+
+/*
+ * Index files determine the list of .deb archives to download.
+ * They are rewritten when packages are filtered.
+ */
+
+type MirrorIndexItem struct { // Packages.xz
+	mirror    *pkgMirror
+	source    *MirrorSource
+	entryName string // Ex: main/binary-amd64/Packages.xz
+	checksum  string // Expected SHA256 checksum
+}
+
+func NewMirrorIndexItem(mirror *pkgMirror, source *MirrorSource, entryName string, checksum string) *MirrorIndexItem {
+	return &MirrorIndexItem{
+		mirror:    mirror,
+		source:    source,
+		entryName: entryName,
+		checksum:  checksum,
+	}
+}
+
+func (i *MirrorIndexItem) DownloadURI() string {
+	// Ex: http://deb.debian.org/debian/dists/buster/main/binary-amd64/Packages.xz
+	return i.source.URI + "/dists/" + i.source.Dist + "/" + i.entryName
+}
+
+func (i *MirrorIndexItem) DestFile(uri string) string {
+	// Ex: /srv/mirror/mirror/deb.debian.org/debian/dists/buster/main/binary-amd64/Packages.xz
+	return filepath.Join(i.source.Dir(i.mirror.config.BasePath), "dists", i.source.Dist, filepath.FromSlash(i.entryName))
+}
+
+func (i *MirrorIndexItem) Done(c *CacheFile, acq *pkgAcquire) error {
+	path := i.DestFile(i.DownloadURI())
+	i.mirror.reference(path)
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to open file %s: %v", path, err)
+	}
+
+	// Check integrity
+	if checksum := fmt.Sprintf("%x", sha256.Sum256(b)); checksum != i.checksum {
+		return fmt.Errorf("found SHA256 mismatch for %s: %v != %v", path, checksum, i.checksum)
+	}
+
+	// Extract content
+	r, err := xz.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("unable to open xz file: %v", err)
+	}
+	parser, err := deb822.NewParser(r)
+	if err != nil {
+		return fmt.Errorf("malformed index file: %v", err)
+	}
+	doc, err := parser.Parse()
+	if err != nil {
+		return fmt.Errorf("malformed index file: %v", err)
+	}
+
+	// Download the referenced archives
+	var accepted deb822.Document
+	for _, paragraph := range doc.Paragraphs {
+		if !i.mirror.config.Accept(paragraph.Value("Package")) {
+			continue
+		}
+		accepted.Paragraphs = append(accepted.Paragraphs, paragraph)
+
+		// Ex: pool/main/r/rsync/rsync_3.2.3-4_amd64.deb
+		filename := paragraph.Value("Filename")
+		if filename == "" || !filepath.IsLocal(filepath.FromSlash(filename)) {
+			return fmt.Errorf("unsafe Filename %q for package %s in %s", filename, paragraph.Value("Package"), path)
+		}
+		item := NewMirrorPackageItem(i.mirror, i.source, paragraph)
+		dest := item.DestFile(item.DownloadURI())
+		i.mirror.reference(dest)
+		if checksum, err := fileSHA256(dest); err == nil && checksum == paragraph.Value("SHA256") {
+			// Already up-to-date
+			continue
+		}
+		acq.Add(item)
+	}
+
+	if i.mirror.config.Filtered() {
+		return i.rewrite(path, accepted)
+	}
+	return nil
+}
+
+// rewrite replaces the index file by the list of mirrored packages.
+func (i *MirrorIndexItem) rewrite(path string, doc deb822.Document) error {
+	formatter := deb822.NewFormatter()
+	formatter.SetFoldedFields("Description")
+	var content bytes.Buffer
+	w, err := xz.NewWriter(&content)
+	if err != nil {
+		return err
+	}
+	if len(doc.Paragraphs) > 0 {
+		if _, err := io.WriteString(w, formatter.Format(doc)+"\n"); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, content.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	release := i.mirror.release(i.source)
+	i.mirror.releasesMutex.Lock()
+	release.entries[i.entryName] = content.Bytes()
+	i.mirror.releasesMutex.Unlock()
+	return nil
+}
+
+func (i MirrorIndexItem) String() string {
+	// Ex: http://deb.debian.org/debian buster/main/binary-amd64/Packages.xz
+	return fmt.Sprintf("%s %s/%s", i.source.URI, i.source.Dist, i.entryName)
+}
+
+/*
+ * Archives are stored under pool/ using the path present in the field Filename.
+ */
+
+type MirrorPackageItem struct { // .deb
+	mirror *pkgMirror
+	source *MirrorSource
+	doc    deb822.Paragraph
+}
+
+func NewMirrorPackageItem(mirror *pkgMirror, source *MirrorSource, doc deb822.Paragraph) *MirrorPackageItem {
+	return &MirrorPackageItem{
+		mirror: mirror,
+		source: source,
+		doc:    doc,
+	}
+}
+
+func (i *MirrorPackageItem) DownloadURI() string {
+	// Ex: http://deb.debian.org/debian/pool/main/r/rsync/rsync_3.2.3-4_amd64.deb
+	return i.source.URI + "/" + i.doc.Value("Filename")
+}
+
+func (i *MirrorPackageItem) DestFile(uri string) string {
+	// Ex: /srv/mirror/mirror/deb.debian.org/debian/pool/main/r/rsync/rsync_3.2.3-4_amd64.deb
+	return filepath.Join(i.source.Dir(i.mirror.config.BasePath), filepath.FromSlash(i.doc.Value("Filename")))
+}
+
+func (i *MirrorPackageItem) Done(c *CacheFile, acq *pkgAcquire) error {
+	path := i.DestFile(i.DownloadURI())
+	checksum, err := fileSHA256(path)
+	if err != nil {
+		return err
+	}
+	if checksum != i.doc.Value("SHA256") {
+		return fmt.Errorf("invalid checksum for %s", path)
+	}
+	return nil
+}
+
+func (i MirrorPackageItem) String() string {
+	// Ex: http://deb.debian.org/debian buster rsync amd64 3.2.3-4
+	return fmt.Sprintf("%s %s %s %s %s", i.source.URI, i.source.Dist, i.doc.Value("Package"), i.doc.Value("Architecture"), i.doc.Value("Version"))
+}
+
+// readKeyRing reads a binary keyring (ex: exported using gpg --export).
+func readKeyRing(path string) (openpgp.EntityList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening key: %s", err)
+	}
+	defer f.Close()
+	keyring, err := openpgp.ReadKeyRing(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %s: %v", path, err)
+	}
+	return keyring, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
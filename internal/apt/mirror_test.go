@@ -0,0 +1,260 @@
+package apt_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/apt"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+	"github.com/ulikunitz/xz"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+func TestMirror(t *testing.T) {
+	testdir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	// A local repository containing two packages
+	hello := []byte("hello archive")
+	world := []byte("world archive")
+	packages := xzCompress(t, []byte(fmt.Sprintf(`Package: hello
+Version: 1.1-1
+Architecture: amd64
+Filename: pool/main/h/hello/hello_1.1-1_amd64.deb
+SHA256: %x
+
+Package: world
+Version: 1.0-1
+Architecture: amd64
+Filename: pool/main/w/world/world_1.0-1_amd64.deb
+SHA256: %x
+`, sha256.Sum256(hello), sha256.Sum256(world))))
+
+	// A key to sign the Release file regenerated after filtering
+	entity, err := openpgp.NewEntity("Mirror", "", "mirror@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var signingKey bytes.Buffer
+	if err := entity.SerializePrivate(&signingKey, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	testfiles := map[string][]byte{
+		"repo/dists/buster/InRelease": []byte(fmt.Sprintf(`Origin: Test
+Codename: buster
+SHA256:
+ %x %d main/binary-amd64/Packages.xz
+`, sha256.Sum256(packages), len(packages))),
+		"repo/dists/buster/main/binary-amd64/Packages.xz": packages,
+		"signing-key.gpg": signingKey.Bytes(),
+		"repo/pool/main/h/hello/hello_1.1-1_amd64.deb": hello,
+		"repo/pool/main/w/world/world_1.0-1_amd64.deb": world,
+
+		// Files from a previous synchronization
+		"mirror/mirror/" + testdir + "/repo/pool/main/o/old/old_0.1-1_amd64.deb":       []byte("obsolete"),
+		"mirror/mirror/" + testdir + "/repo/dists/buster/main/binary-i386/Packages.xz": []byte("obsolete"),
+
+		"mirror.list": []byte(fmt.Sprintf(`
+# Mirror only the hello package
+set base_path %s/mirror
+set architectures amd64
+set signing_key %s/signing-key.gpg
+deb file://%s/repo buster main
+include ^hello$
+`, testdir, testdir, testdir)),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+
+	apt.Mirror(filepath.Join(testdir, "mirror.list"))
+
+	mirrorDir := filepath.Join(testdir, "mirror/mirror", testdir, "repo")
+	// The index file lists only the mirrored packages
+	mirroredPackages, err := os.ReadFile(filepath.Join(mirrorDir, "dists/buster/main/binary-amd64/Packages.xz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := xz.NewReader(bytes.NewReader(mirroredPackages))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedIndex := fmt.Sprintf(`Package: hello
+Version: 1.1-1
+Architecture: amd64
+Filename: pool/main/h/hello/hello_1.1-1_amd64.deb
+SHA256: %x
+
+`, sha256.Sum256(hello))
+	if string(index) != expectedIndex {
+		t.Errorf("Unexpected index file:\n%s\nExpected:\n%s", index, expectedIndex)
+	}
+
+	// The Release file lists the checksums of the rewritten index file and is signed
+	expectedRelease := fmt.Sprintf(`Origin: Test
+Codename: buster
+MD5Sum:
+ %x %8d main/binary-amd64/Packages.xz
+SHA256:
+ %x %8d main/binary-amd64/Packages.xz
+`, md5.Sum(mirroredPackages), len(mirroredPackages), sha256.Sum256(mirroredPackages), len(mirroredPackages))
+	testutil.CheckFileContains(t, filepath.Join(mirrorDir, "dists/buster/Release"), expectedRelease)
+	keyring := openpgp.EntityList{entity}
+	inRelease, err := os.ReadFile(filepath.Join(mirrorDir, "dists/buster/InRelease"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := clearsign.Decode(inRelease)
+	if b == nil {
+		t.Fatalf("InRelease is not signed")
+	}
+	if _, err := openpgp.CheckDetachedSignature(keyring, bytes.NewReader(b.Bytes), b.ArmoredSignature.Body); err != nil {
+		t.Errorf("Invalid InRelease signature: %v", err)
+	}
+	if string(b.Plaintext) != expectedRelease {
+		t.Errorf("Unexpected InRelease content:\n%s", b.Plaintext)
+	}
+	signature, err := os.Open(filepath.Join(mirrorDir, "dists/buster/Release.gpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer signature.Close()
+	if _, err := openpgp.CheckArmoredDetachedSignature(keyring, strings.NewReader(expectedRelease), signature); err != nil {
+		t.Errorf("Invalid Release.gpg signature: %v", err)
+	}
+
+	testutil.CheckFileContains(t, filepath.Join(mirrorDir, "pool/main/h/hello/hello_1.1-1_amd64.deb"), string(hello))
+	if _, err := os.Stat(filepath.Join(mirrorDir, "pool/main/w/world/world_1.0-1_amd64.deb")); !os.IsNotExist(err) {
+		t.Errorf("Excluded package world must not be mirrored")
+	}
+	if _, err := os.Stat(filepath.Join(mirrorDir, "pool/main/o/old/old_0.1-1_amd64.deb")); !os.IsNotExist(err) {
+		t.Errorf("Obsolete package old must be removed")
+	}
+	if _, err := os.Stat(filepath.Join(mirrorDir, "dists/buster/main/binary-i386/Packages.xz")); !os.IsNotExist(err) {
+		t.Errorf("Obsolete index file must be removed")
+	}
+}
+
+func TestMirrorManyPackages(t *testing.T) {
+	testdir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	// More archives than the capacity of the download channels
+	const count = 10000
+	testfiles := make(map[string][]byte)
+	var index bytes.Buffer
+	for i := 0; i < count; i++ {
+		content := []byte(fmt.Sprintf("archive %d", i))
+		filename := fmt.Sprintf("pool/main/p/pkg%d/pkg%d_1.0_amd64.deb", i, i)
+		testfiles["repo/"+filename] = content
+		fmt.Fprintf(&index, "Package: pkg%d\nVersion: 1.0\nArchitecture: amd64\nFilename: %s\nSHA256: %x\n\n", i, filename, sha256.Sum256(content))
+	}
+	packages := xzCompress(t, index.Bytes())
+	testfiles["repo/dists/buster/InRelease"] = []byte(fmt.Sprintf(`Origin: Test
+Codename: buster
+SHA256:
+ %x %d main/binary-amd64/Packages.xz
+`, sha256.Sum256(packages), len(packages)))
+	testfiles["repo/dists/buster/main/binary-amd64/Packages.xz"] = packages
+	testfiles["repo/dists/buster/Release"] = testfiles["repo/dists/buster/InRelease"]
+	testfiles["repo/dists/buster/Release.gpg"] = []byte("signature")
+	testfiles["mirror.list"] = []byte(fmt.Sprintf(`
+set base_path %s/mirror
+deb file://%s/repo buster main
+`, testdir, testdir))
+	testutil.PopulateTestDir(t, testdir, testfiles)
+
+	done := make(chan bool)
+	var output string
+	go func() {
+		output = testutil.CaptureStdout(t, func() { apt.Mirror(filepath.Join(testdir, "mirror.list")) })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Minute):
+		t.Fatal("Mirror did not complete")
+	}
+
+	// No keyring is configured to check the signatures
+	if !strings.Contains(output, fmt.Sprintf("W: The Release file of file://%s/repo buster is not verified (no keyring)", testdir)) {
+		t.Errorf("Missing warning about the unverified Release file:\n%s", output)
+	}
+
+	// Files are mirrored as-is without filters
+	mirrorDir := filepath.Join(testdir, "mirror/mirror", testdir, "repo")
+	testutil.CheckFileContains(t, filepath.Join(mirrorDir, "dists/buster/Release"), string(testfiles["repo/dists/buster/Release"]))
+	testutil.CheckFileContains(t, filepath.Join(mirrorDir, "dists/buster/Release.gpg"), "signature")
+	testutil.CheckFileContains(t, filepath.Join(mirrorDir, "dists/buster/main/binary-amd64/Packages.xz"), string(packages))
+	testutil.CheckFileContains(t, filepath.Join(mirrorDir, fmt.Sprintf("pool/main/p/pkg%d/pkg%d_1.0_amd64.deb", count-1, count-1)), fmt.Sprintf("archive %d", count-1))
+}
+
+func TestMirrorUnsafeFilename(t *testing.T) {
+	testdir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	for _, filename := range []string{"../../../outside.deb", "/tmp/outside.deb", "pool/../../outside.deb"} {
+		content := []byte("evil archive")
+		packages := xzCompress(t, []byte(fmt.Sprintf("Package: evil\nVersion: 1.0\nFilename: %s\nSHA256: %x\n", filename, sha256.Sum256(content))))
+		testutil.PopulateTestDir(t, testdir, map[string][]byte{
+			"repo/dists/buster/InRelease":                     []byte(fmt.Sprintf("Origin: Test\nSHA256:\n %x %d main/binary-amd64/Packages.xz\n", sha256.Sum256(packages), len(packages))),
+			"repo/dists/buster/Release":                       []byte("Origin: Test\n"),
+			"repo/dists/buster/Release.gpg":                   []byte("signature"),
+			"repo/dists/buster/main/binary-amd64/Packages.xz": packages,
+			"outside.deb":                                     content,
+		})
+		config, err := apt.ParseMirrorConfig(fmt.Sprintf("set base_path %s/mirror\ndeb file://%s/repo buster main\n", testdir, testdir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var runErr error
+		testutil.CaptureStdout(t, func() { runErr = apt.NewPkgMirror(config).Run() })
+		if runErr == nil || !strings.Contains(runErr.Error(), "unsafe Filename") {
+			t.Errorf("Expected an error for Filename %s, got %v", filename, runErr)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(testdir, "mirror/outside.deb")); !os.IsNotExist(err) {
+		t.Errorf("Archives must not be written outside the mirror")
+	}
+}
+
+/* Test Helpers */
+
+func xzCompress(t *testing.T, content []byte) []byte {
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,195 @@
+package apt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+/*
+ * The installation order is determined like APT does (see pkgOrderList):
+ *
+ * - Pre-Depends must be configured before the package is unpacked.
+ * - Depends must be configured before the package is configured,
+ *   but can be unpacked in any order.
+ *
+ * Packages depending on each other (ex: libc6 and perl) form strongly connected components.
+ * All packages of a component are unpacked together before being configured.
+ */
+
+type Action struct {
+	Type    string // unpack or configure
+	Package *Package
+	Loop    []string // Packages of the strongly connected component, if any
+}
+
+func (a Action) String() string {
+	return fmt.Sprintf("%s %s", a.Type, a.Package.Name())
+}
+
+// OrderInstallation returns the sequence of unpack and configure actions
+// to install the packages marked for installation.
+func (c *CacheFile) OrderInstallation() ([]Action, error) {
+	marked := make(map[string]bool)
+	var names []string
+	for _, name := range c.depCache.order {
+		if !marked[name] {
+			marked[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// Dependencies between packages marked for installation
+	edges := func(name string, types ...string) []string {
+		var targets []string
+		for _, relation := range c.GetPackage(name).Relations() {
+			if !contains(types, relation.Type) {
+				continue
+			}
+			for _, dep := range relation.Alternatives {
+				if marked[dep.Name] && dep.Name != name {
+					targets = append(targets, dep.Name)
+				}
+			}
+		}
+		return targets
+	}
+
+	var actions []Action
+	for _, component := range stronglyConnectedComponents(names, func(name string) []string {
+		return edges(name, "Pre-Depends", "Depends")
+	}) {
+		componentActions, err := c.orderComponent(component, func(name string) []string {
+			return edges(name, "Pre-Depends")
+		})
+		if err != nil {
+			return nil, err
+		}
+		actions = append(actions, componentActions...)
+	}
+	return actions, nil
+}
+
+// orderComponent orders the actions inside a strongly connected component.
+func (c *CacheFile) orderComponent(component []string, preDepends func(string) []string) ([]Action, error) {
+	var actions []Action
+	var loop []string
+	if len(component) > 1 {
+		loop = component
+	}
+	inComponent := make(map[string]bool)
+	for _, name := range component {
+		inComponent[name] = true
+	}
+	unpacked := make(map[string]bool)
+	configured := make(map[string]bool)
+
+	for len(unpacked) < len(component) {
+		// Unpack all packages whose pre-dependencies are configured
+		var unpackable []string
+		for _, name := range component {
+			if unpacked[name] {
+				continue
+			}
+			ready := true
+			for _, dep := range preDepends(name) {
+				if inComponent[dep] && !configured[dep] {
+					ready = false
+				}
+			}
+			if ready {
+				unpackable = append(unpackable, name)
+			}
+		}
+		if len(unpackable) == 0 {
+			return nil, fmt.Errorf("pre-dependency loop between packages %s", strings.Join(component, ", "))
+		}
+		for _, name := range unpackable {
+			actions = append(actions, Action{Type: "unpack", Package: c.GetPackage(name), Loop: loop})
+			unpacked[name] = true
+		}
+
+		// Configure immediately the packages required by remaining pre-dependencies
+		for _, name := range component {
+			if unpacked[name] {
+				continue
+			}
+			for _, dep := range preDepends(name) {
+				if inComponent[dep] && unpacked[dep] && !configured[dep] {
+					actions = append(actions, Action{Type: "configure", Package: c.GetPackage(dep), Loop: loop})
+					configured[dep] = true
+				}
+			}
+		}
+	}
+
+	for _, name := range component {
+		if !configured[name] {
+			actions = append(actions, Action{Type: "configure", Package: c.GetPackage(name), Loop: loop})
+		}
+	}
+	return actions, nil
+}
+
+// stronglyConnectedComponents implements Tarjan's algorithm.
+// Components are returned in reverse topological order (dependencies first).
+func stronglyConnectedComponents(nodes []string, edges func(string) []string) [][]string {
+	index := 0
+	indices := make(map[string]int)
+	lowlinks := make(map[string]int)
+	onStack := make(map[string]bool)
+	var stack []string
+	var components [][]string
+
+	var strongConnect func(node string)
+	strongConnect = func(node string) {
+		indices[node] = index
+		lowlinks[node] = index
+		index++
+		stack = append(stack, node)
+		onStack[node] = true
+
+		for _, target := range edges(node) {
+			if _, visited := indices[target]; !visited {
+				strongConnect(target)
+				if lowlinks[target] < lowlinks[node] {
+					lowlinks[node] = lowlinks[target]
+				}
+			} else if onStack[target] && indices[target] < lowlinks[node] {
+				lowlinks[node] = indices[target]
+			}
+		}
+
+		if lowlinks[node] == indices[node] {
+			var component []string
+			for {
+				last := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[last] = false
+				component = append(component, last)
+				if last == node {
+					break
+				}
+			}
+			sort.Strings(component)
+			components = append(components, component)
+		}
+	}
+
+	for _, node := range nodes {
+		if _, visited := indices[node]; !visited {
+			strongConnect(node)
+		}
+	}
+	return components
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,185 @@
+package apt_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/diff"
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/apt"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestOrderInstallation(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		// perl and libperl depend on each other (like perl-base and libc6)
+		"perl/DEBIAN/control": []byte(`Package: perl
+Version: 5.28.1-6
+Architecture: all
+Maintainer: Julien Sobczak
+Pre-Depends: dpkg
+Depends: libperl
+Description: Larry Wall's Practical Extraction and Report Language
+`),
+		"libperl/DEBIAN/control": []byte(`Package: libperl
+Version: 5.28.1-6
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: perl, libc
+Description: shared Perl library
+`),
+		"dpkg/DEBIAN/control": []byte(`Package: dpkg
+Version: 1.19.7
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: libc
+Description: Debian package management system
+`),
+		"libc/DEBIAN/control": []byte(`Package: libc
+Version: 2.28-10
+Architecture: all
+Maintainer: Julien Sobczak
+Description: GNU C Library
+`),
+
+		"/var/lib/dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "perl", "libperl", "dpkg", "libc")
+
+	cache := &apt.CacheFile{}
+	cache.Open()
+	cache.MarkForInstallation("perl")
+	actions, err := cache.OrderInstallation()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var actual string
+	for _, action := range actions {
+		actual += action.String() + "\n"
+	}
+	expected := `unpack libc
+configure libc
+unpack dpkg
+configure dpkg
+unpack libperl
+unpack perl
+configure libperl
+configure perl
+`
+	if actual != expected {
+		t.Errorf("Unexpected actions:\n%v", diff.LineDiff(actual, expected))
+	}
+}
+
+func TestOrderInstallationPreDependencyLoop(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"chicken/DEBIAN/control": []byte(`Package: chicken
+Version: 1.0
+Architecture: all
+Maintainer: Julien Sobczak
+Pre-Depends: egg
+Description: Chicken
+`),
+		"egg/DEBIAN/control": []byte(`Package: egg
+Version: 1.0
+Architecture: all
+Maintainer: Julien Sobczak
+Pre-Depends: chicken
+Description: Egg
+`),
+
+		"/var/lib/dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "chicken", "egg")
+
+	cache := &apt.CacheFile{}
+	cache.Open()
+	cache.MarkForInstallation("chicken")
+	if _, err := cache.OrderInstallation(); err == nil {
+		t.Errorf("Expected a pre-dependency loop error")
+	}
+}
+
+func TestInstallPreDependsInLoop(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		// libperl must be configured before perl is unpacked but depends on perl
+		"perl/DEBIAN/control": []byte(`Package: perl
+Version: 5.28.1-6
+Architecture: all
+Maintainer: Julien Sobczak
+Pre-Depends: libperl
+Description: Larry Wall's Practical Extraction and Report Language
+`),
+		"perl/usr/bin/perl": []byte(`perl`),
+		"libperl/DEBIAN/control": []byte(`Package: libperl
+Version: 5.28.1-6
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: perl
+Description: shared Perl library
+`),
+		"libperl/usr/lib/libperl.so": []byte(`library`),
+
+		"/var/lib/dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "perl", "libperl")
+	if err := os.MkdirAll(filepath.Join(testdir, "/var/lib/dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := &apt.CacheFile{}
+	cache.Open()
+	cache.MarkForInstallation("perl")
+	actions, err := cache.OrderInstallation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual string
+	for _, action := range actions {
+		actual += action.String() + "\n"
+	}
+	expected := `unpack libperl
+configure libperl
+unpack perl
+configure perl
+`
+	if actual != expected {
+		t.Errorf("Unexpected actions:\n%v", diff.LineDiff(actual, expected))
+	}
+
+	apt.Install([]string{"perl"})
+	status, err := ioutil.ReadFile(filepath.Join(testdir, "/var/lib/dpkg/status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(status), "Status: install ok installed") != 2 {
+		t.Errorf("Unexpected status:\n%s", status)
+	}
+}
@@ -0,0 +1,186 @@
+package apt
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+)
+
+// Stdin is used to read answers to prompts.
+var Stdin io.Reader = os.Stdin
+
+// Plan summarizes the changes of a transaction before applying them.
+type Plan struct {
+	Installs   []*Package // New packages
+	Upgrades   []*Package
+	Downgrades []*Package
+	Reinstalls []*Package
+	Removals   []*Package // Installed packages to remove
+	HeldBack   []string   // Upgradable packages kept at their current version
+
+	DownloadSize int64 // In bytes
+	DiskUsage    int64 // Difference of installed size in bytes
+}
+
+// ComputePlan determines the changes from the packages marked for installation
+// and the given installed packages to remove.
+func (c *CacheFile) ComputePlan(removals []string, heldBack []string) *Plan {
+	plan := &Plan{
+		HeldBack: heldBack,
+	}
+
+	for _, pkgName := range removals {
+		pkg := c.GetInstalledPackage(pkgName)
+		if pkg == nil {
+			continue
+		}
+		plan.Removals = append(plan.Removals, pkg)
+		plan.DiskUsage -= pkg.InstalledSize()
+	}
+
+	for _, pkgName := range c.depCache.order {
+		pkg := c.GetPackage(pkgName)
+		installed := c.GetInstalledPackage(pkgName)
+		if installed == nil {
+			plan.Installs = append(plan.Installs, pkg)
+		} else {
+			switch cmp := dpkg.CompareVersions(pkg.Version(), installed.Version()); {
+			case cmp > 0:
+				plan.Upgrades = append(plan.Upgrades, pkg)
+			case cmp < 0:
+				plan.Downgrades = append(plan.Downgrades, pkg)
+			default:
+				plan.Reinstalls = append(plan.Reinstalls, pkg)
+			}
+			plan.DiskUsage -= installed.InstalledSize()
+		}
+		plan.DiskUsage += pkg.InstalledSize()
+		if !pkg.local && !NewPackageItem(pkg).Verify() {
+			// Archive not already present in /var/cache/apt/archives/
+			plan.DownloadSize += pkg.Size()
+		}
+	}
+
+	return plan
+}
+
+// Empty returns true when the plan has no change.
+func (p *Plan) Empty() bool {
+	return len(p.Installs)+len(p.Upgrades)+len(p.Downgrades)+len(p.Reinstalls)+len(p.Removals) == 0
+}
+
+func (p Plan) String() string {
+	var sb strings.Builder
+	list := func(header string, pkgs []*Package) {
+		if len(pkgs) == 0 {
+			return
+		}
+		var names []string
+		for _, pkg := range pkgs {
+			names = append(names, pkg.Name())
+		}
+		sb.WriteString(fmt.Sprintf("%s\n\t%s\n", header, strings.Join(names, " ")))
+	}
+
+	if len(p.HeldBack) > 0 {
+		sb.WriteString(fmt.Sprintf("The following packages have been kept back:\n\t%s\n", strings.Join(p.HeldBack, " ")))
+	}
+	list("The following packages will be REMOVED:", p.Removals)
+	list("The following NEW packages will be installed:", p.Installs)
+	list("The following packages will be upgraded:", p.Upgrades)
+	list("The following packages will be DOWNGRADED:", p.Downgrades)
+
+	sb.WriteString(fmt.Sprintf("%d upgraded, %d newly installed, ", len(p.Upgrades), len(p.Installs)))
+	if len(p.Downgrades) > 0 {
+		sb.WriteString(fmt.Sprintf("%d downgraded, ", len(p.Downgrades)))
+	}
+	if len(p.Reinstalls) > 0 {
+		sb.WriteString(fmt.Sprintf("%d reinstalled, ", len(p.Reinstalls)))
+	}
+	sb.WriteString(fmt.Sprintf("%d to remove and %d not upgraded.\n", len(p.Removals), len(p.HeldBack)))
+
+	if p.DownloadSize > 0 {
+		sb.WriteString(fmt.Sprintf("Need to get %s of archives.\n", humanReadable(p.DownloadSize)))
+	}
+	if p.DiskUsage > 0 {
+		sb.WriteString(fmt.Sprintf("After this operation, %s of additional disk space will be used.\n", humanReadable(p.DiskUsage)))
+	} else if p.DiskUsage < 0 {
+		sb.WriteString(fmt.Sprintf("After this operation, %s disk space will be freed.\n", humanReadable(-p.DiskUsage)))
+	}
+	return sb.String()
+}
+
+// Simulation returns the actions in the format of apt-get --simulate.
+func (p *Plan) Simulation(c *CacheFile) (string, error) {
+	actions, err := c.OrderInstallation()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, pkg := range p.Removals {
+		// Ex: Remv hello [1.1-1]
+		sb.WriteString(fmt.Sprintf("Remv %s [%s]\n", pkg.Name(), pkg.Version()))
+	}
+	for _, action := range actions {
+		pkg := action.Package
+		switch action.Type {
+		case "unpack":
+			// Ex: Inst hello [1.1-1] (2.1-1 Debian:buster [all])
+			current := ""
+			if installed := c.GetInstalledPackage(pkg.Name()); installed != nil {
+				current = fmt.Sprintf(" [%s]", installed.Version())
+			}
+			sb.WriteString(fmt.Sprintf("Inst %s%s (%s %s [%s])\n", pkg.Name(), current, pkg.Version(), pkg.Release(), pkg.Architecture()))
+		case "configure":
+			// Ex: Conf hello (2.1-1 Debian:buster [all])
+			sb.WriteString(fmt.Sprintf("Conf %s (%s %s [%s])\n", pkg.Name(), pkg.Version(), pkg.Release(), pkg.Architecture()))
+		}
+	}
+	return sb.String(), nil
+}
+
+// Confirm asks the user to continue. Returns true if the answer is yes.
+func Confirm() bool {
+	if AssumeYes {
+		return true
+	}
+	fmt.Printf("Do you want to continue? [Y/n] ")
+	answer, err := bufio.NewReader(Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Println()
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "" || answer == "y" || answer == "yes"
+}
+
+// Size returns the size of the archive in bytes.
+func (p *Package) Size() int64 {
+	size, _ := strconv.ParseInt(p.doc.Value("Size"), 10, 64)
+	return size
+}
+
+// InstalledSize returns the estimated disk usage in bytes.
+func (p *Package) InstalledSize() int64 {
+	// The field Installed-Size is expressed in KiB
+	size, _ := strconv.ParseInt(p.doc.Value("Installed-Size"), 10, 64)
+	return size * 1024
+}
+
+// Release returns the archive containing the package (ex: Debian:buster).
+func (p *Package) Release() string {
+	if p.local || p.source == nil {
+		return "local"
+	}
+	suite := p.source.Suite
+	if suite == "" {
+		suite = p.source.Codename
+	}
+	return fmt.Sprintf("%s:%s", p.source.Origin, suite)
+}
@@ -0,0 +1,98 @@
+package apt_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andreyvit/diff"
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/apt"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestPlan(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello/DEBIAN/control": []byte(`Package: hello
+Version: 2.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Installed-Size: 20
+Depends: libhello
+Description: Say Hello
+`),
+		"libhello/DEBIAN/control": []byte(`Package: libhello
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Installed-Size: 100
+Description: Hello library
+`),
+
+		"/var/lib/dpkg/status": []byte(`Package: hello
+Status: install ok installed
+Architecture: all
+Installed-Size: 10
+Version: 1.1-1
+`),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "hello", "libhello")
+
+	cache := &apt.CacheFile{}
+	cache.Open()
+	plan := cache.MarkUpgrades(true)
+
+	var downloadSize int64
+	for _, archive := range []string{"hello.deb", "libhello.deb"} {
+		info, err := os.Stat(filepath.Join(testdir, "repo/pool/main", archive))
+		if err != nil {
+			t.Fatal(err)
+		}
+		downloadSize += info.Size()
+	}
+	expected := fmt.Sprintf(`The following NEW packages will be installed:
+	libhello
+The following packages will be upgraded:
+	hello
+1 upgraded, 1 newly installed, 0 to remove and 0 not upgraded.
+Need to get %.1f kB of archives.
+After this operation, 112.6 kB of additional disk space will be used.
+`, float64(downloadSize)/1000)
+	if plan.String() != expected {
+		t.Errorf("Unexpected plan:\n%v", diff.LineDiff(plan.String(), expected))
+	}
+
+	expected = `Inst libhello (1.0-1 Test:buster [all])
+Conf libhello (1.0-1 Test:buster [all])
+Inst hello [1.1-1] (2.1-1 Test:buster [all])
+Conf hello (2.1-1 Test:buster [all])
+`
+	actual, err := plan.Simulation(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual != expected {
+		t.Errorf("Unexpected simulation:\n%v", diff.LineDiff(actual, expected))
+	}
+
+	// Confirmation prompt
+	apt.AssumeYes = false
+	defer func() { apt.AssumeYes = true }()
+	defer func() { apt.Stdin = os.Stdin }()
+	for answer, expected := range map[string]bool{"\n": true, "y\n": true, "Yes\n": true, "n\n": false, "": false} {
+		apt.Stdin = strings.NewReader(answer)
+		if actual := apt.Confirm(); actual != expected {
+			t.Errorf("Unexpected confirmation for answer %q: %v", answer, actual)
+		}
+	}
+}
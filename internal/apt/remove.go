@@ -0,0 +1,240 @@
+package apt
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+)
+
+func Remove(args []string, purge bool, autoremove bool) {
+	if !Simulate {
+		defer lockFrontend()()
+	}
+
+	cache := &CacheFile{}
+	cache.OpenLocal()
+
+	// Packages already removed can still be purged
+	var configFiles map[string]*Package
+	if purge {
+		var err error
+		configFiles, err = configFilesPackages()
+		if err != nil {
+			fmt.Printf("E: The package lists or status file could not be parsed or opened.\n")
+			os.Exit(1)
+		}
+	}
+
+	var pkgNames []string
+	var purges []*Package
+	for _, pkgName := range args {
+		if cache.GetInstalledPackage(pkgName) == nil {
+			if pkg, ok := configFiles[pkgName]; ok {
+				purges = append(purges, pkg)
+				continue
+			}
+			fmt.Printf("Package '%s' is not installed, so not removed\n", pkgName)
+			continue
+		}
+		pkgNames = append(pkgNames, pkgName)
+	}
+
+	removals := cache.MarkForRemoval(pkgNames, autoremove)
+
+	// Print out the list of packages no longer used
+	excluded := make(map[string]bool)
+	for _, pkgName := range removals {
+		excluded[pkgName] = true
+	}
+	if orphans := cache.Orphans(excluded); len(orphans) > 0 {
+		fmt.Printf("The following packages were automatically installed and are no longer required:\n\t%s\n", strings.Join(orphans, " "))
+		fmt.Printf("Use 'apt autoremove' to remove them.\n")
+	}
+
+	plan := cache.ComputePlan(removals, nil)
+	plan.Removals = append(plan.Removals, purges...)
+	for _, pkg := range purges {
+		removals = append(removals, pkg.Name())
+	}
+	fmt.Print(plan)
+	if plan.Empty() {
+		return
+	}
+	if Simulate {
+		simulation, err := plan.Simulation(cache)
+		if err != nil {
+			fmt.Printf("E: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(simulation)
+		return
+	}
+	if !Confirm() {
+		fmt.Printf("Abort.\n")
+		os.Exit(1)
+	}
+
+	dpkg.Remove(removals, purge)
+
+	// Forget about removed packages
+	cache.OpenLocal()
+	if err := cache.SaveExtendedStates(); err != nil {
+		fmt.Printf("E: Unable to write extended states\n\t%s\n", err)
+		os.Exit(1)
+	}
+}
+
+// configFilesPackages returns the packages removed but not purged
+// whose configuration files are still present (status "config-files").
+func configFilesPackages() (map[string]*Package, error) {
+	status, err := ParseStatus()
+	if err != nil {
+		return nil, err
+	}
+	pkgs := make(map[string]*Package)
+	for _, paragraph := range status.Paragraphs {
+		if strings.HasSuffix(paragraph.Value("Status"), " config-files") {
+			pkgs[paragraph.Value("Package")] = &Package{
+				doc: paragraph,
+			}
+		}
+	}
+	return pkgs, nil
+}
+
+// MarkForRemoval returns the installed packages to remove in order to remove
+// the given packages, including the packages depending on them.
+// When autoremove is true, packages no longer required are removed too.
+// Packages are ordered so that a package is removed before its dependencies.
+func (c *CacheFile) MarkForRemoval(pkgNames []string, autoremove bool) []string {
+	removals := make(map[string]bool)
+	for _, pkgName := range pkgNames {
+		removals[pkgName] = true
+	}
+
+	// Remove packages whose dependencies are no longer satisfied
+	for changed := true; changed; {
+		changed = false
+		for _, pkg := range c.GetInstalledPackages() {
+			if removals[pkg.Name()] {
+				continue
+			}
+			if !c.satisfied(pkg, removals) {
+				removals[pkg.Name()] = true
+				changed = true
+			}
+		}
+	}
+
+	if autoremove {
+		for _, pkgName := range c.Orphans(removals) {
+			removals[pkgName] = true
+		}
+	}
+
+	// Order the removals
+	var pending []string
+	for pkgName := range removals {
+		pending = append(pending, pkgName)
+	}
+	sort.Strings(pending)
+	var order []string
+	for len(pending) > 0 {
+		next := 0 // Break cycles arbitrarily
+		for i, candidate := range pending {
+			if !c.requiredBy(candidate, pending) {
+				next = i
+				break
+			}
+		}
+		order = append(order, pending[next])
+		pending = append(pending[:next], pending[next+1:]...)
+	}
+	return order
+}
+
+// Orphans returns the packages automatically installed that are no longer
+// required by any manually installed package, ignoring the excluded packages.
+func (c *CacheFile) Orphans(excluded map[string]bool) []string {
+	required := make(map[string]bool)
+
+	var queue []string
+	for _, pkg := range c.GetInstalledPackages() {
+		if !excluded[pkg.Name()] && !c.depCache.states[pkg.Name()].Auto() {
+			queue = append(queue, pkg.Name())
+		}
+	}
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		if required[name] {
+			continue
+		}
+		required[name] = true
+		for _, relation := range c.GetInstalledPackage(name).Relations() {
+			if relation.Type != "Pre-Depends" && relation.Type != "Depends" && relation.Type != "Recommends" {
+				// Recommends are considered important like APT does by default
+				continue
+			}
+			for _, dep := range relation.Alternatives {
+				if c.GetInstalledPackage(dep.Name) != nil && !excluded[dep.Name] {
+					queue = append(queue, dep.Name)
+				}
+			}
+		}
+	}
+
+	var orphans []string
+	for _, pkg := range c.GetInstalledPackages() {
+		if !required[pkg.Name()] && !excluded[pkg.Name()] {
+			orphans = append(orphans, pkg.Name())
+		}
+	}
+	return orphans
+}
+
+// satisfied returns true if the dependencies of an installed package are still satisfied
+// after the removal of the given packages.
+func (c *CacheFile) satisfied(pkg *Package, removals map[string]bool) bool {
+	for _, relation := range pkg.Relations() {
+		if relation.Type != "Depends" && relation.Type != "Pre-Depends" {
+			continue
+		}
+		satisfied := false
+		impacted := false
+		for _, dep := range relation.Alternatives {
+			if removals[dep.Name] {
+				impacted = true
+			} else if c.GetInstalledPackage(dep.Name) != nil {
+				satisfied = true
+			}
+		}
+		if impacted && !satisfied {
+			return false
+		}
+	}
+	return true
+}
+
+// requiredBy returns true if one of the given packages depends on the package.
+func (c *CacheFile) requiredBy(pkgName string, pkgNames []string) bool {
+	for _, other := range pkgNames {
+		if other == pkgName {
+			continue
+		}
+		for _, relation := range c.GetInstalledPackage(other).Relations() {
+			if relation.Type != "Depends" && relation.Type != "Pre-Depends" {
+				continue
+			}
+			for _, dep := range relation.Alternatives {
+				if dep.Name == pkgName {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package apt_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/apt"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestAutoRemove(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello/DEBIAN/control": []byte(`Package: hello
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: libhello
+Description: Say Hello
+`),
+		"hello/usr/bin/hello": []byte(`#!/bin/sh
+echo "Hello"
+`),
+		"libhello/DEBIAN/control": []byte(`Package: libhello
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Hello library
+`),
+		"libhello/usr/lib/libhello.so": []byte(`library`),
+
+		"/var/lib/dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "hello", "libhello")
+	if err := os.MkdirAll(filepath.Join(testdir, "/var/lib/dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Install hello and its dependency
+	apt.Install([]string{"hello"})
+	testutil.CheckFileExists(t, filepath.Join(testdir, "/usr/bin/hello"))
+	testutil.CheckFileExists(t, filepath.Join(testdir, "/usr/lib/libhello.so"))
+	testutil.CheckFileContains(t, filepath.Join(testdir, "/var/lib/apt/extended_states"), `Package: libhello
+Architecture: all
+Auto-Installed: 1
+`)
+
+	// libhello is still required by hello
+	cache := &apt.CacheFile{}
+	cache.OpenLocal()
+	if orphans := cache.Orphans(nil); len(orphans) > 0 {
+		t.Errorf("Unexpected orphans: %v", orphans)
+	}
+	if removals := cache.MarkForRemoval([]string{"libhello"}, false); len(removals) != 2 || removals[0] != "hello" {
+		t.Errorf("Unexpected removals: %v", removals)
+	}
+
+	// Removing hello also removes libhello
+	apt.Remove([]string{"hello"}, false, true)
+	if _, err := os.Stat(filepath.Join(testdir, "/usr/bin/hello")); !os.IsNotExist(err) {
+		t.Errorf("File /usr/bin/hello must be removed")
+	}
+	if _, err := os.Stat(filepath.Join(testdir, "/usr/lib/libhello.so")); !os.IsNotExist(err) {
+		t.Errorf("File /usr/lib/libhello.so must be removed")
+	}
+	testutil.CheckFileContains(t, filepath.Join(testdir, "/var/lib/apt/extended_states"), ``)
+}
+
+func TestPurgeConfigFiles(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello/DEBIAN/control": []byte(`Package: hello
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"hello/DEBIAN/conffiles": []byte("/etc/hello.conf\n"),
+		"hello/etc/hello.conf":   []byte("lang=en\n"),
+		"hello/usr/bin/hello":    []byte(`#!/bin/sh`),
+		"/var/lib/dpkg/status":   []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "hello")
+	if err := os.MkdirAll(filepath.Join(testdir, "/var/lib/dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Remove hello but keep its configuration files
+	apt.Install([]string{"hello"})
+	apt.Remove([]string{"hello"}, false, false)
+	testutil.CheckFileExists(t, filepath.Join(testdir, "/etc/hello.conf"))
+	status, err := ioutil.ReadFile(filepath.Join(testdir, "/var/lib/dpkg/status"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(status), "Status: deinstall ok config-files\n") {
+		t.Errorf("Unexpected status:\n%s", status)
+	}
+
+	// Purge the configuration files
+	output := testutil.CaptureStdout(t, func() { apt.Remove([]string{"hello"}, true, false) })
+	if !strings.Contains(output, "The following packages will be REMOVED:\n\thello\n") {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+	if _, err := os.Stat(filepath.Join(testdir, "/etc/hello.conf")); !os.IsNotExist(err) {
+		t.Errorf("File /etc/hello.conf must be purged")
+	}
+	testutil.CheckFileContains(t, filepath.Join(testdir, "/var/lib/dpkg/status"), ``)
+}
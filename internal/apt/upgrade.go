@@ -0,0 +1,239 @@
+package apt
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+)
+
+func Upgrade(full bool) {
+	if !Simulate {
+		defer lockFrontend()()
+	}
+
+	// Load the Cache
+	cache := &CacheFile{}
+	cache.Open()
+
+	plan := cache.MarkUpgrades(full)
+	fmt.Print(plan)
+	if plan.Empty() {
+		return
+	}
+	if Simulate {
+		simulation, err := plan.Simulation(cache)
+		if err != nil {
+			fmt.Printf("E: %s\n", err)
+			os.Exit(1)
+		}
+		fmt.Print(simulation)
+		return
+	}
+	if !Confirm() {
+		fmt.Printf("Abort.\n")
+		os.Exit(1)
+	}
+
+	if len(plan.Removals) > 0 {
+		var removals []string
+		for _, pkg := range plan.Removals {
+			removals = append(removals, pkg.Name())
+			state := cache.depCache.states[pkg.Name()]
+			state.CurrentVersion = ""
+			state.current = nil
+		}
+		dpkg.Remove(removals, false)
+	}
+
+	err := InstallPackages(cache)
+	if err != nil {
+		fmt.Printf("E: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// MarkUpgrades marks the upgradable packages for installation.
+// When full is false, packages requiring new installations or removals are held back.
+// When full is true, missing dependencies are installed and conflicting packages are removed.
+func (c *CacheFile) MarkUpgrades(full bool) *Plan {
+	upgrades := make(map[string]bool)
+	installs := make(map[string]bool)
+	removals := make(map[string]bool)
+	held := make(map[string]bool)     // Upgrades that cannot be satisfied
+	rejected := make(map[string]bool) // New installations that cannot be satisfied
+
+	for _, pkg := range c.GetInstalledPackages() {
+		if c.depCache.states[pkg.Name()].Upgradable() && c.GetPackage(pkg.Name()) != nil {
+			upgrades[pkg.Name()] = true
+		}
+	}
+
+	// version returns the version of a package after the upgrade
+	version := func(name string) string {
+		if removals[name] {
+			return ""
+		}
+		if upgrades[name] || installs[name] {
+			return c.GetPackage(name).Version()
+		}
+		if pkg := c.GetInstalledPackage(name); pkg != nil {
+			return pkg.Version()
+		}
+		return ""
+	}
+	satisfied := func(relation Relation) bool {
+		for _, dep := range relation.Alternatives {
+			if dep.SatisfiedBy(version(dep.Name)) {
+				return true
+			}
+		}
+		return false
+	}
+	// satisfiedBefore returns true if the relation is satisfied by the installed packages.
+	// Relations already broken are ignored as the upgrade cannot fix them.
+	satisfiedBefore := func(relation Relation) bool {
+		for _, dep := range relation.Alternatives {
+			if pkg := c.GetInstalledPackage(dep.Name); pkg != nil && dep.SatisfiedBy(pkg.Version()) {
+				return true
+			}
+		}
+		return false
+	}
+	// abandon gives up the upgrade or installation of a package
+	abandon := func(name string) {
+		if upgrades[name] {
+			delete(upgrades, name)
+			held[name] = true
+		} else {
+			delete(installs, name)
+			rejected[name] = true
+		}
+	}
+	// resolve tries to satisfy a relation by installing or upgrading an alternative
+	resolve := func(relation Relation) bool {
+		for _, dep := range relation.Alternatives {
+			pkg := c.GetPackage(dep.Name)
+			if pkg == nil || rejected[dep.Name] || held[dep.Name] || removals[dep.Name] || !dep.SatisfiedBy(pkg.Version()) {
+				continue
+			}
+			if installed := c.GetInstalledPackage(dep.Name); installed != nil {
+				// Never downgrade or reinstall the same version
+				if dpkg.CompareVersions(pkg.Version(), installed.Version()) <= 0 {
+					continue
+				}
+				upgrades[dep.Name] = true
+			} else {
+				installs[dep.Name] = true
+			}
+			return true
+		}
+		return false
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		// Check the new versions
+		for _, name := range append(sortedKeys(upgrades), sortedKeys(installs)...) {
+			candidate := c.GetPackage(name)
+			for _, relation := range candidate.Relations() {
+				if relation.Type != "Depends" && relation.Type != "Pre-Depends" {
+					continue
+				}
+				if satisfied(relation) {
+					continue
+				}
+				if !full || !resolve(relation) {
+					abandon(name)
+				}
+				changed = true
+				break
+			}
+			if !upgrades[name] && !installs[name] {
+				continue
+			}
+			for _, conflict := range candidate.Conflicts() {
+				if conflict.Name == name || !conflict.SatisfiedBy(version(conflict.Name)) {
+					continue
+				}
+				if full && !upgrades[conflict.Name] && !installs[conflict.Name] {
+					removals[conflict.Name] = true
+				} else {
+					abandon(name)
+				}
+				changed = true
+				break
+			}
+		}
+
+		// Check the packages kept at the same version
+		for _, pkg := range c.GetInstalledPackages() {
+			name := pkg.Name()
+			if upgrades[name] || removals[name] {
+				continue
+			}
+			for _, relation := range pkg.Relations() {
+				if relation.Type != "Depends" && relation.Type != "Pre-Depends" {
+					continue
+				}
+				if satisfied(relation) || !satisfiedBefore(relation) {
+					continue
+				}
+				if full {
+					removals[name] = true
+					changed = true
+					break
+				}
+				// Keep the dependencies at their current version
+				for _, dep := range relation.Alternatives {
+					if upgrades[dep.Name] {
+						abandon(dep.Name)
+						changed = true
+					}
+				}
+			}
+		}
+	}
+
+	// Determine the installation order
+	var visit func(name string)
+	visit = func(name string) {
+		pkg := c.GetPackage(name)
+		state := c.GetState(pkg)
+		if state.Install() {
+			return
+		}
+		state.CandidateVersion = pkg.Version()
+		state.flagInstall = true
+		if installs[name] {
+			state.flagAuto = true
+		}
+		for _, relation := range pkg.Relations() {
+			if relation.Type != "Depends" && relation.Type != "Pre-Depends" {
+				continue
+			}
+			for _, dep := range relation.Alternatives {
+				if upgrades[dep.Name] || installs[dep.Name] {
+					visit(dep.Name)
+				}
+			}
+		}
+		c.depCache.order = append(c.depCache.order, name)
+	}
+	for _, name := range append(sortedKeys(upgrades), sortedKeys(installs)...) {
+		visit(name)
+	}
+
+	return c.ComputePlan(sortedKeys(removals), sortedKeys(held))
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,229 @@
+package apt_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/apt"
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestUpgrade(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		// Installed versions
+		"hello-1.1-1/DEBIAN/control": []byte(`Package: hello
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"hello-1.1-1/usr/bin/hello": []byte(`hello 1.1`),
+		"world-1.0-1/DEBIAN/control": []byte(`Package: world
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Print the world
+`),
+		"world-1.0-1/usr/bin/world": []byte(`world 1.0`),
+
+		// New versions
+		"hello-2.1-1/DEBIAN/control": []byte(`Package: hello
+Version: 2.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: libhello
+Description: Say Hello
+`),
+		"hello-2.1-1/usr/bin/hello": []byte(`hello 2.1`),
+		"libhello-1.0-1/DEBIAN/control": []byte(`Package: libhello
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Hello library
+`),
+		"libhello-1.0-1/usr/lib/libhello.so": []byte(`library`),
+		"world-1.1-1/DEBIAN/control": []byte(`Package: world
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Print the world
+`),
+		"world-1.1-1/usr/bin/world": []byte(`world 1.1`),
+
+		"/var/lib/dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "hello-2.1-1", "libhello-1.0-1", "world-1.1-1")
+	if err := os.MkdirAll(filepath.Join(testdir, "/var/lib/dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Install the old versions
+	var archives []string
+	for _, pkgdir := range []string{"hello-1.1-1", "world-1.0-1"} {
+		archive := filepath.Join(testdir, pkgdir+".deb")
+		dpkg.Build(filepath.Join(testdir, pkgdir), archive)
+		archives = append(archives, archive)
+	}
+	dpkg.Install(archives)
+
+	// Upgrade cannot install libhello
+	cache := &apt.CacheFile{}
+	cache.Open()
+	plan := cache.MarkUpgrades(false)
+	if len(plan.HeldBack) != 1 || plan.HeldBack[0] != "hello" {
+		t.Errorf("Unexpected held back packages: %v", plan.HeldBack)
+	}
+	if len(plan.Upgrades) != 1 || plan.Upgrades[0].Name() != "world" {
+		t.Errorf("Unexpected upgrades:\n%s", plan)
+	}
+	if len(plan.Installs) != 0 || len(plan.Removals) != 0 {
+		t.Errorf("Unexpected installs or removals:\n%s", plan)
+	}
+
+	// Full upgrade can
+	apt.Upgrade(true)
+	testutil.CheckFileContains(t, filepath.Join(testdir, "/usr/bin/hello"), `hello 2.1`)
+	testutil.CheckFileContains(t, filepath.Join(testdir, "/usr/bin/world"), `world 1.1`)
+	testutil.CheckFileExists(t, filepath.Join(testdir, "/usr/lib/libhello.so"))
+	testutil.CheckFileContains(t, filepath.Join(testdir, "/var/lib/dpkg/status"), `Package: hello
+Status: install ok installed
+Version: 2.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Installed-Size: 3
+Depends: libhello
+Description: Say Hello
+
+Package: world
+Status: install ok installed
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Installed-Size: 3
+Description: Print the world
+
+Package: libhello
+Status: install ok installed
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Installed-Size: 3
+Description: Hello library
+`)
+	testutil.CheckFileContains(t, filepath.Join(testdir, "/var/lib/apt/extended_states"), `Package: libhello
+Architecture: all
+Auto-Installed: 1
+`)
+}
+
+func TestUpgradeBrokenPackage(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"world-1.1-1/DEBIAN/control": []byte(`Package: world
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Print the world
+`),
+
+		// A package whose dependency is already missing
+		"/var/lib/dpkg/status": []byte(`Package: broken
+Status: install ok installed
+Version: 1.0-1
+Architecture: all
+Depends: missing
+
+Package: world
+Status: install ok installed
+Version: 1.0-1
+Architecture: all
+`),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "world-1.1-1")
+
+	for _, full := range []bool{false, true} {
+		cache := &apt.CacheFile{}
+		cache.Open()
+		done := make(chan *apt.Plan)
+		go func() { done <- cache.MarkUpgrades(full) }()
+		select {
+		case plan := <-done:
+			if len(plan.Upgrades) != 1 || plan.Upgrades[0].Name() != "world" {
+				t.Errorf("Unexpected upgrades (full=%v):\n%s", full, plan)
+			}
+			if len(plan.Removals) != 0 || len(plan.HeldBack) != 0 {
+				t.Errorf("Unexpected removals or held back packages (full=%v):\n%s", full, plan)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("MarkUpgrades(%v) did not return", full)
+		}
+	}
+}
+
+func TestUpgradeNoDowngrade(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"world-1.1-1/DEBIAN/control": []byte(`Package: world
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: libhello (<< 2.0)
+Description: Print the world
+`),
+		"libhello-1.0-1/DEBIAN/control": []byte(`Package: libhello
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Hello library
+`),
+
+		// The new world requires an older libhello than the installed one
+		"/var/lib/dpkg/status": []byte(`Package: libhello
+Status: install ok installed
+Version: 2.0-1
+Architecture: all
+
+Package: world
+Status: install ok installed
+Version: 1.0-1
+Architecture: all
+`),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	populateRepository(t, testdir, "world-1.1-1", "libhello-1.0-1")
+
+	cache := &apt.CacheFile{}
+	cache.Open()
+	plan := cache.MarkUpgrades(true)
+	if len(plan.HeldBack) != 1 || plan.HeldBack[0] != "world" {
+		t.Errorf("Unexpected held back packages: %v", plan.HeldBack)
+	}
+	if len(plan.Upgrades) != 0 || len(plan.Installs) != 0 || len(plan.Removals) != 0 {
+		t.Errorf("Unexpected changes:\n%s", plan)
+	}
+}
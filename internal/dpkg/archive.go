@@ -0,0 +1,245 @@
+package dpkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/blakesmith/ar"
+)
+
+// Errors reported when reading an invalid Debian archive (see ArchiveError).
+var (
+	ErrNotDebianArchive       = errors.New("not a Debian format archive")
+	ErrUnsupportedFormat      = errors.New("unsupported format version")
+	ErrUnexpectedMember       = errors.New("unexpected member")
+	ErrMissingMember          = errors.New("missing member")
+	ErrUnsupportedCompression = errors.New("unknown compression")
+	ErrTruncatedArchive       = errors.New("truncated archive")
+)
+
+// ArchiveError reports an invalid Debian archive.
+// Use errors.Is to check the kind of problem (ex: ErrTruncatedArchive).
+type ArchiveError struct {
+	Path   string
+	Member string // Optional
+	Err    error
+	Detail string // Optional
+}
+
+func (e *ArchiveError) Error() string {
+	msg := fmt.Sprintf("archive '%s'", e.Path)
+	if e.Member != "" {
+		msg += fmt.Sprintf(" member '%s'", e.Member)
+	}
+	msg += fmt.Sprintf(": %v", e.Err)
+	if e.Detail != "" {
+		msg += fmt.Sprintf(" (%s)", e.Detail)
+	}
+	return msg
+}
+
+func (e *ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// archiveMagic starts every ar archive.
+const archiveMagic = "!<arch>\n"
+
+// memberCompressions lists the supported extensions for control.tar and data.tar.
+var memberCompressions = []string{"", ".gz", ".xz", ".zst"}
+
+// Archive reads the members of a Debian archive in order:
+// debian-binary, control.tar[.ext] and data.tar[.ext].
+// Members starting with an underscore are reserved for local use and are skipped.
+type Archive struct {
+	Path    string
+	Version string // Format version read from debian-binary (ex: 2.0)
+
+	file   *os.File
+	size   int64
+	reader *ar.Reader
+	header *ar.Header // Current member
+	next   string     // Next expected member (control.tar, data.tar or "" at the end)
+}
+
+// OpenArchive opens a Debian archive for reading and checks its format version.
+func OpenArchive(path string) (*Archive, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a := &Archive{
+		Path: path,
+		file: f,
+		size: stat.Size(),
+	}
+	if err := a.readVersion(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// readVersion checks the ar header and the debian-binary member.
+func (a *Archive) readVersion() error {
+	magic := make([]byte, len(archiveMagic))
+	if _, err := io.ReadFull(a.file, magic); err != nil || string(magic) != archiveMagic {
+		return &ArchiveError{Path: a.Path, Err: ErrNotDebianArchive}
+	}
+	if _, err := a.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	a.reader = ar.NewReader(a.file)
+
+	header, err := a.nextHeader()
+	if err == io.EOF {
+		return &ArchiveError{Path: a.Path, Err: ErrMissingMember, Member: "debian-binary"}
+	}
+	if err != nil {
+		return err
+	}
+	if header.Name != "debian-binary" {
+		return &ArchiveError{Path: a.Path, Err: ErrNotDebianArchive, Detail: fmt.Sprintf("first member is '%s'", header.Name)}
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, a.reader); err != nil {
+		return err
+	}
+	a.Version = strings.TrimSpace(strings.SplitN(buf.String(), "\n", 2)[0])
+	// Minor versions are compatible
+	if !strings.HasPrefix(a.Version, "2.") {
+		return &ArchiveError{Path: a.Path, Err: ErrUnsupportedFormat, Detail: a.Version}
+	}
+	a.next = "control.tar"
+	return nil
+}
+
+// Close closes the underlying file.
+func (a *Archive) Close() error {
+	return a.file.Close()
+}
+
+// Next advances to the next member (control.tar then data.tar).
+// io.EOF is returned after data.tar.
+func (a *Archive) Next() (*ar.Header, error) {
+	for {
+		if a.next == "" {
+			return nil, io.EOF
+		}
+		header, err := a.nextHeader()
+		if err == io.EOF {
+			return nil, &ArchiveError{Path: a.Path, Err: ErrMissingMember, Member: a.next}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(header.Name, "_") {
+			continue
+		}
+		if !strings.HasPrefix(header.Name, a.next) {
+			return nil, &ArchiveError{Path: a.Path, Err: ErrUnexpectedMember, Member: header.Name, Detail: fmt.Sprintf("expected %s", a.next)}
+		}
+		if !validCompression(strings.TrimPrefix(header.Name, a.next)) {
+			return nil, &ArchiveError{Path: a.Path, Err: ErrUnsupportedCompression, Member: header.Name}
+		}
+		if a.next == "control.tar" {
+			a.next = "data.tar"
+		} else {
+			a.next = ""
+		}
+		return header, nil
+	}
+}
+
+// nextHeader reads the next ar header and checks the member fits in the file.
+func (a *Archive) nextHeader() (*ar.Header, error) {
+	header, err := a.reader.Next()
+	if err == io.ErrUnexpectedEOF {
+		return nil, &ArchiveError{Path: a.Path, Err: ErrTruncatedArchive, Detail: "unexpected end of file in member header"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Ex: GNU ar terminates names with a slash
+	header.Name = strings.TrimSuffix(header.Name, "/")
+	offset, err := a.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if offset+header.Size > a.size {
+		return nil, &ArchiveError{Path: a.Path, Err: ErrTruncatedArchive, Member: header.Name, Detail: fmt.Sprintf("%d bytes expected, %d available", header.Size, a.size-offset)}
+	}
+	a.header = header
+	return header, nil
+}
+
+// validCompression returns true for the supported extensions of control.tar and data.tar.
+func validCompression(extension string) bool {
+	for _, ext := range memberCompressions {
+		if extension == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// Read reads the raw content of the current member.
+func (a *Archive) Read(b []byte) (int, error) {
+	return a.reader.Read(b)
+}
+
+// Tarball returns a reader on the current member after decompression.
+func (a *Archive) Tarball() (*tar.Reader, error) {
+	if a.header == nil {
+		return nil, fmt.Errorf("no current member in archive '%s'", a.Path)
+	}
+	decompressed, err := Decompress(a.header.Name, a.reader)
+	if err != nil {
+		return nil, err
+	}
+	return tar.NewReader(decompressed), nil
+}
+
+// NextMember advances to the member whose name starts with prefix (ex: control.tar).
+func (a *Archive) NextMember(prefix string) (*ar.Header, error) {
+	for {
+		header, err := a.Next()
+		if err == io.EOF {
+			return nil, &ArchiveError{Path: a.Path, Err: ErrMissingMember, Member: prefix}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(header.Name, prefix) {
+			return header, nil
+		}
+	}
+}
+
+// ReadControl returns the uncompressed control tarball.
+func (a *Archive) ReadControl() (bytes.Buffer, error) {
+	return a.readMember("control.tar")
+}
+
+// readMember returns the uncompressed content of the next member starting with prefix.
+func (a *Archive) readMember(prefix string) (bytes.Buffer, error) {
+	var buf bytes.Buffer
+	header, err := a.NextMember(prefix)
+	if err != nil {
+		return buf, err
+	}
+	if err := extractTar(header.Name, &buf, a.reader); err != nil {
+		return buf, &ArchiveError{Path: a.Path, Err: err, Member: header.Name}
+	}
+	return buf, nil
+}
@@ -0,0 +1,190 @@
+package dpkg_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/blakesmith/ar"
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestArchiveValidation(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"src/DEBIAN/control": []byte(`Package: hello
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"src/usr/bin/hello": []byte(`#!/bin/sh`),
+		"dpkg/status":       []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	if err := os.MkdirAll(filepath.Join(testdir, "dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	valid := filepath.Join(testdir, "hello.deb")
+	dpkg.Build(filepath.Join(testdir, "src"), valid)
+	control, data := readMembers(t, valid)
+
+	binary := member{"debian-binary", []byte("2.0\n")}
+	for _, tt := range []struct {
+		name     string
+		members  []member
+		raw      []byte // Used instead of members when defined
+		expected error
+	}{
+		{"valid", []member{binary, control, data}, nil, nil},
+		{"minor version", []member{{"debian-binary", []byte("2.1\n")}, control, data}, nil, nil},
+		{"extra members", []member{binary, {"_gpgorigin", []byte("signature")}, control, {"_extra", []byte("x")}, data}, nil, nil},
+		{"not an archive", nil, []byte("Package: hello\n"), dpkg.ErrNotDebianArchive},
+		{"not a debian archive", []member{control, data}, nil, dpkg.ErrNotDebianArchive},
+		{"unsupported version", []member{{"debian-binary", []byte("3.0\n")}, control, data}, nil, dpkg.ErrUnsupportedFormat},
+		{"data before control", []member{binary, data, control}, nil, dpkg.ErrUnexpectedMember},
+		{"unknown member", []member{binary, {"signature", []byte("x")}, control, data}, nil, dpkg.ErrUnexpectedMember},
+		{"unknown compression", []member{binary, {"control.tar.bz2", control.content}, data}, nil, dpkg.ErrUnsupportedCompression},
+		{"missing data", []member{binary, control}, nil, dpkg.ErrMissingMember},
+		{"truncated member", nil, truncate(t, []member{binary, control, data}, 10), dpkg.ErrTruncatedArchive},
+		{"truncated header", nil, truncate(t, []member{binary, control, data}, int(data.size())+30), dpkg.ErrTruncatedArchive},
+	} {
+		path := filepath.Join(testdir, tt.name+".deb")
+		raw := tt.raw
+		if raw == nil {
+			raw = writeMembers(t, tt.members)
+		}
+		if err := os.WriteFile(path, raw, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := readArchive(path)
+		if tt.expected == nil && err != nil {
+			t.Errorf("Unexpected error for %s: %v", tt.name, err)
+		}
+		if tt.expected != nil {
+			var archiveErr *dpkg.ArchiveError
+			if !errors.Is(err, tt.expected) || !errors.As(err, &archiveErr) {
+				t.Errorf("Expected %v for %s, got %v", tt.expected, tt.name, err)
+			}
+		}
+	}
+
+	// Invalid archives are not installed
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = filepath.Join(testdir, "root")
+	defer func() { dpkg.RootDir = "/" }()
+	dpkg.Install([]string{filepath.Join(testdir, "truncated member.deb")})
+	testutil.CheckFileContains(t, filepath.Join(testdir, "dpkg/status"), ``)
+}
+
+/* Test Helpers */
+
+// member is a file inside an ar archive.
+type member struct {
+	name    string
+	content []byte
+}
+
+func (m member) size() int64 {
+	return int64(len(m.content))
+}
+
+// readMembers returns the raw control and data members of a valid archive.
+func readMembers(t *testing.T, path string) (member, member) {
+	a, err := dpkg.OpenArchive(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	var members []member
+	for {
+		header, err := a.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, member{header.Name, content})
+	}
+	return members[0], members[1]
+}
+
+// writeMembers creates an ar archive.
+func writeMembers(t *testing.T, members []member) []byte {
+	f, err := os.CreateTemp("", "archive.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	w := ar.NewWriter(f)
+	if err := w.WriteGlobalHeader(); err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range members {
+		hdr := &ar.Header{Name: m.name, ModTime: time.Now(), Mode: 0644, Size: m.size()}
+		if err := w.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(m.content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+// truncate creates an ar archive missing its last bytes.
+func truncate(t *testing.T, members []member, n int) []byte {
+	content := writeMembers(t, members)
+	return content[:len(content)-n]
+}
+
+// readArchive reads every member of an archive.
+func readArchive(path string) error {
+	a, err := dpkg.OpenArchive(path)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+	if _, err := a.ReadControl(); err != nil {
+		return err
+	}
+	if _, err := a.NextMember("data.tar"); err != nil {
+		return err
+	}
+	tr, err := a.Tarball()
+	if err != nil {
+		return err
+	}
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return err
+		}
+	}
+}
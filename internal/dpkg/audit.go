@@ -0,0 +1,63 @@
+package dpkg
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// auditChecks lists the abnormal states with the explanation printed by dpkg --audit.
+var auditChecks = []struct {
+	status      string
+	explanation string
+}{
+	{
+		status: "half-installed",
+		explanation: `The following packages are only half installed, due to problems during
+installation.  The installation can probably be completed by retrying it;
+the packages can be removed using dpkg --remove:`,
+	},
+	{
+		status: "unpacked",
+		explanation: `The following packages have been unpacked but not yet configured.
+They must be configured using dpkg --configure for them to work:`,
+	},
+	{
+		status: "half-configured",
+		explanation: `The following packages are only half configured, probably due to problems
+configuring them the first time.  The configuration should be retried using
+dpkg --configure <package>:`,
+	},
+}
+
+// Audit prints the packages left in an abnormal state.
+func Audit() {
+	// Read the database
+	db, err := Load()
+	if err != nil {
+		fmt.Printf("Unable to read the database: %v", err)
+		os.Exit(1)
+	}
+	fmt.Print(db.Audit())
+}
+
+// Audit returns a report of the packages left in an abnormal state
+// (empty when the database is consistent).
+func (d *Directory) Audit() string {
+	var sections []string
+	for _, check := range auditChecks {
+		var sb strings.Builder
+		for _, pkg := range d.Packages {
+			if pkg.Status != check.status {
+				continue
+			}
+			// Ex: " hello                Say Hello"
+			description := strings.SplitN(pkg.Paragraph.Value("Description"), "\n", 2)[0]
+			sb.WriteString(fmt.Sprintf(" %-20s %s\n", pkg.Name(), description))
+		}
+		if sb.Len() > 0 {
+			sections = append(sections, check.explanation+"\n"+sb.String())
+		}
+	}
+	return strings.Join(sections, "\n")
+}
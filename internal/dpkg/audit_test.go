@@ -0,0 +1,97 @@
+package dpkg_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestErrorUnwind(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"1.0-1/DEBIAN/control": []byte(`Package: hello
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"1.0-1/usr/bin/hello": []byte(`hello 1.0`),
+		"2.0-1/DEBIAN/control": []byte(`Package: hello
+Version: 2.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"2.0-1/DEBIAN/preinst": []byte(`#!/bin/sh
+test -f ` + testdir + `/disk || exit 1
+`),
+		"2.0-1/DEBIAN/postrm": []byte(`#!/bin/sh
+echo "postrm $@" >> ` + testdir + `/postrm.log
+`),
+		"2.0-1/usr/bin/hello": []byte(`hello 2.0`),
+
+		"dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	if err := os.MkdirAll(filepath.Join(testdir, "dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, pkgdir := range []string{"1.0-1", "2.0-1"} {
+		dpkg.Build(filepath.Join(testdir, pkgdir), filepath.Join(testdir, pkgdir+".deb"))
+	}
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = testdir
+	defer func() { dpkg.RootDir = "/" }()
+
+	// A failed installation is forgotten
+	dpkg.Install([]string{filepath.Join(testdir, "2.0-1.deb")})
+	testutil.CheckFileContains(t, filepath.Join(testdir, "dpkg/status"), ``)
+	testutil.CheckFileContains(t, filepath.Join(testdir, "postrm.log"), "postrm abort-install\n")
+	if _, err := os.Stat(filepath.Join(testdir, "usr/bin/hello")); !os.IsNotExist(err) {
+		t.Errorf("File /usr/bin/hello must not be unpacked")
+	}
+
+	// A failed upgrade restores the previous version
+	dpkg.Install([]string{filepath.Join(testdir, "1.0-1.deb")})
+	dpkg.Install([]string{filepath.Join(testdir, "2.0-1.deb")})
+	testutil.CheckFileContains(t, filepath.Join(testdir, "postrm.log"), "postrm abort-install\npostrm abort-upgrade 1.0-1\n")
+	testutil.CheckFileContains(t, filepath.Join(testdir, "usr/bin/hello"), `hello 1.0`)
+	checkStatus(t, "hello", "installed")
+	if _, err := os.Stat(filepath.Join(testdir, "dpkg/info/hello.postrm")); !os.IsNotExist(err) {
+		t.Errorf("Maintainer scripts of the failed version must be removed")
+	}
+
+	// Interrupted installations are reported
+	db, err := dpkg.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report := db.Audit(); report != "" {
+		t.Errorf("Unexpected audit report:\n%s", report)
+	}
+	if err := ioutil.WriteFile(filepath.Join(testdir, "disk"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dpkg.Unpack([]string{filepath.Join(testdir, "2.0-1.deb")})
+	db, err = dpkg.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `The following packages have been unpacked but not yet configured.
+They must be configured using dpkg --configure for them to work:
+ hello                Say Hello
+`
+	if report := db.Audit(); report != expected {
+		t.Errorf("Unexpected audit report:\n%s", report)
+	}
+}
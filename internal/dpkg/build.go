@@ -2,11 +2,15 @@ package dpkg
 
 import (
 	"archive/tar"
-	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/blakesmith/ar"
 )
@@ -20,6 +24,17 @@ func Build(directory string, dest string) {
 	}
 	defer fdeb.Close()
 
+	// Use a fixed timestamp for reproducible builds
+	epoch, err := sourceDateEpoch()
+	if err != nil {
+		fmt.Printf("dpkg-deb: %s\n", err)
+		os.Exit(1)
+	}
+	buildTime := time.Now()
+	if epoch != nil {
+		buildTime = *epoch
+	}
+
 	writer := ar.NewWriter(fdeb)
 	if err := writer.WriteGlobalHeader(); err != nil {
 		fmt.Printf("dpkg-deb: %s\n", err)
@@ -27,20 +42,30 @@ func Build(directory string, dest string) {
 	}
 
 	// Append debian-binary
-	err = arPutFile(writer, "debian-binary", []byte("2.0\n"))
+	err = arPutFile(writer, "debian-binary", []byte("2.0\n"), buildTime)
 	if err != nil {
 		fmt.Printf("dpkg-deb: %s\n", err)
 		os.Exit(1)
 	}
 
-	// Append control.tar
-	controlDir := filepath.Join(directory, "DEBIAN")
-	controlTarball, err := tarballPack(controlDir, nil)
+	extension, err := compressionExtension(Compression)
 	if err != nil {
 		fmt.Printf("dpkg-deb: %s\n", err)
 		os.Exit(1)
 	}
-	err = arPutFile(writer, "control.tar", controlTarball)
+
+	// Complete the control files
+	generated, err := generateControlFiles(directory)
+	if err != nil {
+		fmt.Printf("dpkg-deb: error: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Append control.tar
+	controlDir := filepath.Join(directory, "DEBIAN")
+	err = arPutTarball(writer, "control.tar"+extension, buildTime, func(w io.Writer) error {
+		return tarballPack(w, controlDir, nil, epoch, generated)
+	})
 	if err != nil {
 		fmt.Printf("dpkg-deb: %s\n", err)
 		os.Exit(1)
@@ -48,28 +73,100 @@ func Build(directory string, dest string) {
 
 	// Append data.tar
 	dataDir := directory
-	dataTarball, err := tarballPack(dataDir, func(path string) bool {
-		return strings.HasPrefix(path, controlDir)
+	err = arPutTarball(writer, "data.tar"+extension, buildTime, func(w io.Writer) error {
+		return tarballPack(w, dataDir, func(path string) bool {
+			return strings.HasPrefix(path, controlDir)
+		}, epoch, nil)
 	})
 	if err != nil {
 		fmt.Printf("dpkg-deb: %s\n", err)
 		os.Exit(1)
 	}
-	err = arPutFile(writer, "data.tar", dataTarball)
+}
+
+/** sourceDateEpoch returns the timestamp defined by SOURCE_DATE_EPOCH, if any. */
+func sourceDateEpoch() (*time.Time, error) {
+	value := os.Getenv("SOURCE_DATE_EPOCH")
+	if value == "" {
+		return nil, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
 	if err != nil {
-		fmt.Printf("dpkg-deb: %s\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %v", value, err)
+	}
+	epoch := time.Unix(seconds, 0).UTC()
+	return &epoch, nil
+}
+
+/**
+ * arPutTarball appends a compressed tarball in an ar archive.
+ * The tarball is streamed to a temporary file as the ar header requires the size upfront.
+ */
+func arPutTarball(w *ar.Writer, name string, modTime time.Time, pack func(io.Writer) error) error {
+	tmp, err := os.CreateTemp("", "dpkg-deb.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	compressor, err := compressWriter(tmp, Compression, CompressionLevel)
+	if err != nil {
+		return err
+	}
+	if err := pack(compressor); err != nil {
+		return err
+	}
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	hdr := &ar.Header{
+		Name:    name,
+		ModTime: modTime,
+		Uid:     0,
+		Gid:     0,
+		Mode:    0644,
+		Size:    size,
+	}
+	if err := w.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	// The ar writer pads every odd-sized write. Only the last chunk can be odd-sized.
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := io.ReadFull(tmp, buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 	}
 }
 
 /** arPutFile appends a new file in an ar archive. */
-func arPutFile(w *ar.Writer, name string, body []byte) error {
+func arPutFile(w *ar.Writer, name string, body []byte, modTime time.Time) error {
 	hdr := &ar.Header{
-		Name: name,
-		Uid:  0,
-		Gid:  0,
-		Mode: 0644,
-		Size: int64(len(body)),
+		Name:    name,
+		ModTime: modTime,
+		Uid:     0,
+		Gid:     0,
+		Mode:    0644,
+		Size:    int64(len(body)),
 	}
 	if err := w.WriteHeader(hdr); err != nil {
 		return err
@@ -80,47 +177,147 @@ func arPutFile(w *ar.Writer, name string, body []byte) error {
 	return nil
 }
 
-/** tarballPack appends every file under directory that passes the filter in the tar archive. */
-func tarballPack(directory string, filter func(string) bool) ([]byte, error) {
-	var bufdata bytes.Buffer
-	twdata := tar.NewWriter(&bufdata)
+/**
+ * tarballPack appends every file under directory that passes the filter in the tar archive.
+ * Files are added in lexical order (see filepath.Walk) and their mtimes are clamped to epoch if defined.
+ * Reproducible builds (epoch defined) also package files as owned by root unless mapped otherwise.
+ * Generated files override the files present in the directory or are appended at the end.
+ */
+func tarballPack(w io.Writer, directory string, filter func(string) bool, epoch *time.Time, generated map[string][]byte) error {
+	twdata := tar.NewWriter(w)
+	written := make(map[string]bool)
+	hardlinks := make(map[[2]uint64]string) // (device, inode) => first name in the archive
 	err := filepath.Walk(directory, func(path string, info os.FileInfo, errParent error) error {
-		if info.IsDir() {
+		if errParent != nil {
+			return errParent
+		}
+		if path == directory {
 			return nil
 		}
 		if filter != nil && filter(path) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		sep := fmt.Sprintf("%c", filepath.Separator)
-		hdr := &tar.Header{
-			Name: strings.TrimPrefix(strings.TrimPrefix(path, directory), sep), // Ex: hello/DEBIAN/control => control
-			Uid:  0,                                                            // root
-			Gid:  0,                                                            // root
-			Mode: 0650,
-			Size: info.Size(),
+		name := strings.TrimPrefix(strings.TrimPrefix(path, directory), sep) // Ex: hello/DEBIAN/control => control
+
+		hdr, err := fileHeader(path, name, info, hardlinks, epoch != nil)
+		if err != nil {
+			return err
+		}
+		if epoch != nil && hdr.ModTime.After(*epoch) {
+			hdr.ModTime = *epoch
+		}
+		if content, ok := generated[name]; ok && hdr.Typeflag == tar.TypeReg {
+			written[name] = true
+			hdr.Size = int64(len(content))
+			if err := twdata.WriteHeader(hdr); err != nil {
+				return fmt.Errorf("error while adding a new header in tarball: %v", err)
+			}
+			_, err := twdata.Write(content)
+			return err
 		}
 		if err := twdata.WriteHeader(hdr); err != nil {
-			fmt.Printf("Error while adding a new header in tarball: %s", err)
-			os.Exit(1)
+			return fmt.Errorf("error while adding a new header in tarball: %v", err)
 		}
-		content, err := os.ReadFile(path)
+		if hdr.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := os.Open(path)
 		if err != nil {
-			fmt.Printf("Error while reading file %s: %s", path, err)
-			os.Exit(1)
+			return fmt.Errorf("error while reading file %s: %v", path, err)
 		}
-		if _, err := twdata.Write(content); err != nil {
-			fmt.Printf("Error while adding a new file in tarball: %s", err)
-			os.Exit(1)
+		defer f.Close()
+		if _, err := io.Copy(twdata, f); err != nil {
+			return fmt.Errorf("error while adding a new file in tarball: %v", err)
 		}
 		return nil
 	})
 
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	// Append the remaining generated files
+	var names []string
+	for name := range generated {
+		if !written[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	modTime := time.Now()
+	if epoch != nil {
+		modTime = *epoch
 	}
-	if err := twdata.Close(); err != nil {
+	for _, name := range names {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0644,
+			Size:    int64(len(generated[name])),
+			ModTime: modTime,
+			Uname:   RootOwner.Uname,
+			Gname:   RootOwner.Gname,
+		}
+		if err := twdata.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("error while adding a new header in tarball: %v", err)
+		}
+		if _, err := twdata.Write(generated[name]); err != nil {
+			return fmt.Errorf("error while adding a new file in tarball: %v", err)
+		}
+	}
+	return twdata.Close()
+}
+
+/**
+ * fileHeader creates the tar header of a file preserving its type, mode and modification time.
+ * The owner of the file is kept unless rootOwned (or RootOwnerGroup) is true.
+ */
+func fileHeader(path string, name string, info os.FileInfo, hardlinks map[[2]uint64]string, rootOwned bool) (*tar.Header, error) {
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return nil, err
+		}
+		link = target
+	}
+	hdr, err := tar.FileInfoHeader(info, link)
+	if err != nil {
 		return nil, err
 	}
+	hdr.Name = name
+	if info.IsDir() {
+		hdr.Name += "/"
+	}
+	// Keep the archive in the USTAR format
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
 
-	return bufdata.Bytes(), nil
+	// Files sharing the same inode are stored as hard links
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && info.Mode().IsRegular() && stat.Nlink > 1 {
+		key := [2]uint64{uint64(stat.Dev), uint64(stat.Ino)}
+		if first, ok := hardlinks[key]; ok {
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = first
+			hdr.Size = 0
+		} else {
+			hardlinks[key] = name
+		}
+	}
+
+	// Apply the ownership like fakeroot
+	owner, ok := OwnerMapping["/"+strings.TrimSuffix(name, "/")]
+	if !ok && (RootOwnerGroup || rootOwned) {
+		owner, ok = RootOwner, true
+	}
+	if ok {
+		hdr.Uid = owner.Uid
+		hdr.Gid = owner.Gid
+		hdr.Uname = owner.Uname
+		hdr.Gname = owner.Gname
+	}
+	return hdr, nil
 }
@@ -3,11 +3,16 @@ package dpkg_test
 import (
 	"archive/tar"
 	"bytes"
+	"crypto/sha256"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 
 	"github.com/andreyvit/diff"
 	"github.com/blakesmith/ar"
@@ -49,8 +54,218 @@ echo "Test";
 	dest := filepath.Join(testdir, "test.deb")
 	dpkg.Build(directory, dest)
 
-	// Check the resulting package archive
+	// Check the resulting package archive including the generated control files
+	testfiles["1.1-1/DEBIAN/control"] = []byte(`Package: test
+Version: 1.1-1
+Section: base
+Priority: optional
+Architecture: all
+Maintainer: Julien Sobczak
+Installed-Size: 3
+Description: Test
+`)
+	testfiles["1.1-1/DEBIAN/md5sums"] = []byte(`a6101e71800b523cb9533b074387d693  usr/bin/test
+`)
 	checkDebianArchive(t, dest, testfiles)
+	if names := controlNames(t, dest); strings.Join(names, " ") != "control preinst md5sums" {
+		t.Errorf("Unexpected control files: %v", names)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"src/DEBIAN/control": []byte(`Package: hello
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"src/usr/bin/hello":         []byte(`#!/bin/sh`),
+		"src/etc/hello/secret.conf": []byte(`password=hello`),
+		"dpkg/status":               []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	if err := os.MkdirAll(filepath.Join(testdir, "dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(testdir, "src")
+	mtime := time.Date(2021, 3, 14, 15, 9, 26, 0, time.UTC)
+	for path, mode := range map[string]os.FileMode{
+		"usr/bin/hello":         0755,
+		"etc/hello/secret.conf": 0600,
+		"etc/hello":             0700,
+	} {
+		if err := os.Chmod(filepath.Join(src, path), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chtimes(filepath.Join(src, "usr/bin/hello"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("hello", filepath.Join(src, "usr/bin/hi")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(src, "usr/bin/hello"), filepath.Join(src, "usr/bin/hey")); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Mkfifo(filepath.Join(src, "usr/bin/pipe"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// Build
+	dpkg.OwnerMapping["/etc/hello/secret.conf"] = dpkg.Owner{Uid: 33, Gid: 4}
+	defer delete(dpkg.OwnerMapping, "/etc/hello/secret.conf")
+	archive := filepath.Join(testdir, "hello.deb")
+	dpkg.Build(src, archive)
+
+	// Unpack
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = filepath.Join(testdir, "root")
+	defer func() { dpkg.RootDir = "/" }()
+	dpkg.Unpack([]string{archive})
+	root := dpkg.RootDir
+
+	checkMode(t, filepath.Join(root, "usr/bin/hello"), 0755)
+	checkMode(t, filepath.Join(root, "etc/hello"), os.ModeDir|0700)
+	checkMode(t, filepath.Join(root, "etc/hello/secret.conf"), 0600)
+	checkMode(t, filepath.Join(root, "usr/bin/pipe"), os.ModeNamedPipe|0640)
+	if target, err := os.Readlink(filepath.Join(root, "usr/bin/hi")); err != nil || target != "hello" {
+		t.Errorf("Unexpected symlink target %q (%v)", target, err)
+	}
+	hello, err := os.Stat(filepath.Join(root, "usr/bin/hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hey, err := os.Stat(filepath.Join(root, "usr/bin/hey"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(hello, hey) {
+		t.Errorf("Files /usr/bin/hello and /usr/bin/hey must be hard links")
+	}
+	if !hello.ModTime().Equal(mtime) {
+		t.Errorf("Unexpected modification time %v", hello.ModTime())
+	}
+	if os.Geteuid() == 0 {
+		secret, err := os.Stat(filepath.Join(root, "etc/hello/secret.conf"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat := secret.Sys().(*syscall.Stat_t); stat.Uid != 33 || stat.Gid != 4 {
+			t.Errorf("Unexpected owner %d:%d", stat.Uid, stat.Gid)
+		}
+	}
+	testutil.CheckFileContains(t, filepath.Join(testdir, "dpkg/info/hello.list"), `/etc
+/etc/hello
+/etc/hello/secret.conf
+/usr
+/usr/bin
+/usr/bin/hello
+/usr/bin/hey
+/usr/bin/hi
+/usr/bin/pipe
+`)
+}
+
+func TestBuildCompression(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"1.1-1/DEBIAN/control": []byte(`Package: test
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Installed-Size: 15
+Description: Test
+`),
+		"1.1-1/usr/bin/test": []byte(strings.Repeat("echo \"Test\";\n", 1000)),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	defer func() {
+		dpkg.Compression = "xz"
+		dpkg.CompressionLevel = -1
+	}()
+
+	for _, tt := range []struct {
+		compression string
+		level       int
+		member      string
+	}{
+		{"xz", -1, "data.tar.xz"},
+		{"xz", 0, "data.tar.xz"},
+		{"gzip", 9, "data.tar.gz"},
+		{"zstd", 3, "data.tar.zst"},
+		{"none", -1, "data.tar"},
+	} {
+		dpkg.Compression = tt.compression
+		dpkg.CompressionLevel = tt.level
+		dest := filepath.Join(testdir, fmt.Sprintf("test-%s-%d.deb", tt.compression, tt.level))
+		dpkg.Build(filepath.Join(testdir, "1.1-1"), dest)
+
+		checkDebianArchive(t, dest, testfiles)
+		if members := archiveMembers(t, dest); members[2] != tt.member {
+			t.Errorf("Unexpected members with compression %s: %v", tt.compression, members)
+		}
+	}
+}
+
+func TestReproducibleBuild(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+	epoch := time.Unix(1600000000, 0)
+
+	for _, version := range []string{"1.1-1", "2.1-1", "3.1-1"} {
+		var hashes []string
+		for i, mtime := range []time.Time{time.Now(), time.Now().Add(time.Hour)} {
+			// Copy the hello fixtures at a different location with different mtimes (and owners when possible)
+			pkgdir := filepath.Join(testdir, fmt.Sprintf("hello-%s-%d", version, i))
+			copyTree(t, filepath.Join("../../hello", version), pkgdir)
+			filepath.Walk(pkgdir, func(path string, info os.FileInfo, err error) error {
+				if os.Geteuid() == 0 {
+					if err := os.Lchown(path, 1000+i, 1000+i); err != nil {
+						return err
+					}
+				}
+				return os.Chtimes(path, mtime, mtime)
+			})
+
+			dest := pkgdir + ".deb"
+			dpkg.Build(pkgdir, dest)
+			content, err := ioutil.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hashes = append(hashes, fmt.Sprintf("%x", sha256.Sum256(content)))
+
+			// Modification times are clamped and owners normalized
+			for _, hdr := range dataHeaders(t, dest) {
+				if !hdr.ModTime.Equal(epoch) || hdr.Uname != "root" || hdr.Gname != "root" || hdr.Uid != 0 || hdr.Gid != 0 {
+					t.Errorf("Unexpected metadata for %s: %v %s:%s (%d:%d)", hdr.Name, hdr.ModTime, hdr.Uname, hdr.Gname, hdr.Uid, hdr.Gid)
+				}
+			}
+		}
+		if hashes[0] != hashes[1] {
+			t.Errorf("Builds of hello %s are not reproducible: %s != %s", version, hashes[0], hashes[1])
+		}
+	}
 }
 
 /* Test helpers */
@@ -78,11 +293,15 @@ func checkDebianArchive(t *testing.T, dest string, testfiles map[string][]byte)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if header.Name != "control.tar" {
+	if !strings.HasPrefix(header.Name, "control.tar") {
 		t.Fatalf("Second file must be control.tar")
 	}
 	var bufControl bytes.Buffer
-	io.Copy(&bufControl, reader)
+	decompressed, err := dpkg.Decompress(header.Name, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(&bufControl, decompressed)
 	checkTarArchive(t, bufControl, testfiles, "1.1-1/DEBIAN/")
 
 	// data.tar
@@ -90,11 +309,15 @@ func checkDebianArchive(t *testing.T, dest string, testfiles map[string][]byte)
 	if err != nil {
 		t.Fatal(err)
 	}
-	if header.Name != "data.tar" {
+	if !strings.HasPrefix(header.Name, "data.tar") {
 		t.Fatalf("Third file must be data.tar")
 	}
 	var bufData bytes.Buffer
-	io.Copy(&bufData, reader)
+	decompressed, err = dpkg.Decompress(header.Name, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(&bufData, decompressed)
 	checkTarArchive(t, bufData, testfiles, "1.1-1/")
 }
 
@@ -126,3 +349,127 @@ func checkTarArchive(t *testing.T, buf bytes.Buffer, testfiles map[string][]byte
 		}
 	}
 }
+
+/** checkMode checks the type and permissions of a file. */
+func checkMode(t *testing.T, path string, expected os.FileMode) {
+	info, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != expected {
+		t.Errorf("Unexpected mode for %s: got %v, expected %v", path, info.Mode(), expected)
+	}
+}
+
+/** archiveMembers returns the names of the files in an ar archive. */
+func archiveMembers(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader := ar.NewReader(f)
+	var names []string
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, header.Name)
+	}
+	return names
+}
+
+/** dataHeaders returns the headers of the files in data.tar. */
+func dataHeaders(t *testing.T, path string) []*tar.Header {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader := ar.NewReader(f)
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(header.Name, "data.tar") {
+			continue
+		}
+		decompressed, err := dpkg.Decompress(header.Name, reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var headers []*tar.Header
+		tr := tar.NewReader(decompressed)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return headers
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			headers = append(headers, hdr)
+		}
+	}
+}
+
+/** controlNames returns the names of the files in control.tar. */
+func controlNames(t *testing.T, path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	reader := ar.NewReader(f)
+	for {
+		header, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(header.Name, "control.tar") {
+			continue
+		}
+		decompressed, err := dpkg.Decompress(header.Name, reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		tr := tar.NewReader(decompressed)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				return names
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, hdr.Name)
+		}
+	}
+}
+
+/** copyTree copies the regular files and directories under src to dst preserving their modes. */
+func copyTree(t *testing.T, src string, dst string) {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, strings.TrimPrefix(path, src))
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm())
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, content, info.Mode().Perm())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
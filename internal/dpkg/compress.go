@@ -0,0 +1,92 @@
+package dpkg
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	// Compression is the compressor used for the archive members: xz, gzip, zstd or none (dpkg-deb -Z).
+	Compression string = "xz"
+	// CompressionLevel is the compression level between 0 and 9 (dpkg-deb -z). -1 uses the default level.
+	CompressionLevel int = -1
+)
+
+// xzDictCaps are the dictionary sizes of the xz presets 0-9.
+var xzDictCaps = []int{256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20}
+
+// compressionExtension returns the extension of a member compressed with the given compressor.
+func compressionExtension(compression string) (string, error) {
+	switch compression {
+	case "xz":
+		return ".xz", nil
+	case "gzip":
+		return ".gz", nil
+	case "zstd":
+		return ".zst", nil
+	case "none":
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown compression type '%s'", compression)
+}
+
+// compressWriter wraps w to compress everything written to it.
+// The returned writer must be closed to flush the compressed stream.
+func compressWriter(w io.Writer, compression string, level int) (io.WriteCloser, error) {
+	if level > 9 {
+		return nil, fmt.Errorf("invalid compression level %d", level)
+	}
+	switch compression {
+	case "xz":
+		config := xz.WriterConfig{}
+		if level >= 0 {
+			config.DictCap = xzDictCaps[level]
+		}
+		return config.NewWriter(w)
+	case "gzip":
+		if level < 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case "zstd":
+		// A single goroutine keeps the output deterministic
+		options := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+		if level >= 0 {
+			options = append(options, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, options...)
+	case "none":
+		return nopWriteCloser{w}, nil
+	}
+	return nil, fmt.Errorf("unknown compression type '%s'", compression)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// Decompress returns a reader decompressing the content of a member based on its name (ex: data.tar.xz).
+func Decompress(filename string, reader io.Reader) (io.Reader, error) {
+	switch {
+	case strings.HasSuffix(filename, ".gz"):
+		return gzip.NewReader(reader)
+	case strings.HasSuffix(filename, ".xz"):
+		return xz.NewReader(reader)
+	case strings.HasSuffix(filename, ".zst"):
+		decoder, err := zstd.NewReader(reader)
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	case strings.HasSuffix(filename, ".tar"):
+		return reader, nil
+	}
+	return nil, fmt.Errorf("unsupported compression for member %s", filename)
+}
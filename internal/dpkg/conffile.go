@@ -0,0 +1,223 @@
+package dpkg
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Resolution of conffiles modified both locally and in the new version of the package.
+var (
+	ForceConfOld bool // Keep the modified version (--force-confold)
+	ForceConfNew bool // Install the version of the package (--force-confnew)
+	ForceConfDef bool // Choose the default action without prompting (--force-confdef)
+)
+
+// Stdin is used to read answers to prompts.
+var Stdin io.Reader = os.Stdin
+
+// installConffiles replaces the conffiles by the unpacked versions (<conffile>.dpkg-new)
+// unless they were modified since installation.
+//
+// The current file is compared with the version shipped by the previous version of the package
+// (its hash is stored in the field Conffiles of the status file) and with the new version:
+//   - The file is untouched: the new version is installed.
+//   - The package version did not change: local modifications are kept.
+//   - Both changed: the user decides. The discarded new version is kept as <conffile>.dpkg-dist
+//     and the discarded current version as <conffile>.dpkg-old.
+func (p *PackageInfo) installConffiles() error {
+	previousHashes := ParseConffileHashes(p.Paragraph.Value("Conffiles"))
+	hashes := make(map[string]string)
+
+	for _, conffile := range p.Conffiles {
+		path := filepath.Join(RootDir, conffile)
+		newPath := path + ".dpkg-new"
+		if _, err := os.Stat(newPath); os.IsNotExist(err) {
+			return fmt.Errorf("conffile %s is missing", newPath)
+		}
+
+		newHash, err := fileMD5(newPath)
+		if err != nil {
+			return err
+		}
+		hashes[conffile] = newHash
+		currentHash, err := fileMD5(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		missing := os.IsNotExist(err)
+		oldHash, known := previousHashes[conffile]
+
+		install := true
+		switch {
+		case missing && known:
+			// The administrator deleted the file, do not recreate it
+			install = false
+		case missing || currentHash == newHash:
+			// Nothing to merge
+		case known && currentHash == oldHash:
+			fmt.Printf("Installing new version of config file %s ...\n", conffile)
+		case known && newHash == oldHash:
+			// Keep the local modifications
+			install = false
+		default:
+			install, err = resolveConffile(conffile, known)
+			if err != nil {
+				return err
+			}
+			if install {
+				if err := os.Rename(path, path+".dpkg-old"); err != nil {
+					return err
+				}
+			} else {
+				if err := os.Rename(newPath, path+".dpkg-dist"); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		if install {
+			if err := os.Rename(newPath, path); err != nil {
+				return err
+			}
+		} else if err := os.Remove(newPath); err != nil {
+			return err
+		}
+	}
+
+	if len(hashes) > 0 {
+		p.setField("Conffiles", FormatConffileHashes(hashes))
+	}
+	return nil
+}
+
+// resolveConffile decides what to do with a conffile modified both locally and in the package.
+// Returns true to install the new version.
+func resolveConffile(conffile string, known bool) (bool, error) {
+	fmt.Printf("\nConfiguration file '%s'\n", conffile)
+	if known {
+		fmt.Printf(" ==> Modified (by you or by a script) since installation.\n")
+		fmt.Printf(" ==> Package distributor has shipped an updated version.\n")
+	} else {
+		fmt.Printf(" ==> File on system created by you or by a script.\n")
+		fmt.Printf(" ==> File also in package provided by package maintainer.\n")
+	}
+
+	// Like dpkg, --force-confdef only applies when neither --force-confnew
+	// nor --force-confold is given
+	switch {
+	case ForceConfNew:
+		fmt.Printf(" ==> Using new config file as you requested.\n")
+		return true, nil
+	case ForceConfOld:
+		fmt.Printf(" ==> Keeping old config file as you requested.\n")
+		return false, nil
+	case ForceConfDef:
+		fmt.Printf(" ==> Keeping old config file as default.\n")
+		return false, nil
+	}
+
+	path := filepath.Join(RootDir, conffile)
+	for {
+		fmt.Printf("   What would you like to do about it ?  Your options are:\n")
+		fmt.Printf("    Y or I  : install the package maintainer's version\n")
+		fmt.Printf("    N or O  : keep your currently-installed version\n")
+		fmt.Printf("      D     : show the differences between the versions\n")
+		fmt.Printf(" The default action is to keep your current version.\n")
+		fmt.Printf("*** %s (Y/I/N/O/D) [default=N] ? ", filepath.Base(conffile))
+
+		answer, err := readLine(Stdin)
+		if err != nil && answer == "" {
+			// No answer, use the default action
+			fmt.Println()
+			return false, nil
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "i":
+			return true, nil
+		case "", "n", "o":
+			return false, nil
+		case "d":
+			if err := showDiff(path, path+".dpkg-new"); err != nil {
+				return false, err
+			}
+		}
+	}
+}
+
+// readLine reads a single line without buffering to leave the next answers unread.
+func readLine(r io.Reader) (string, error) {
+	var sb strings.Builder
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return sb.String(), nil
+			}
+			sb.WriteByte(b[0])
+		}
+		if err != nil {
+			return sb.String(), err
+		}
+	}
+}
+
+// showDiff prints the differences between the current and the new version of a conffile.
+func showDiff(current string, new string) error {
+	cmd := exec.Command("diff", "-u", current, new)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		// The files differ
+		return nil
+	}
+	return err
+}
+
+// fileMD5 returns the MD5 checksum of a file.
+func fileMD5(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := md5.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// ParseConffileHashes parses the field Conffiles of the status file.
+// Ex: "/etc/hello/settings.conf 5d41402abc4b2a76b9719d911017c592"
+func ParseConffileHashes(value string) map[string]string {
+	hashes := make(map[string]string)
+	for _, line := range strings.Split(value, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		hashes[fields[0]] = fields[1]
+	}
+	return hashes
+}
+
+// FormatConffileHashes formats the field Conffiles of the status file.
+func FormatConffileHashes(hashes map[string]string) string {
+	var lines []string
+	for conffile, hash := range hashes {
+		lines = append(lines, fmt.Sprintf("%s %s", conffile, hash))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,155 @@
+package dpkg_test
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestConffiles(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"1.0/DEBIAN/control": []byte(`Package: hello
+Version: 1.0
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"1.0/DEBIAN/conffiles": []byte(`/etc/hello/settings.conf
+/etc/hello/untouched.conf
+/etc/hello/same.conf
+/etc/hello/removed.conf
+`),
+		"1.0/etc/hello/settings.conf":  []byte("lang=en\n"),
+		"1.0/etc/hello/untouched.conf": []byte("color=blue\n"),
+		"1.0/etc/hello/same.conf":      []byte("theme=dark\n"),
+		"1.0/etc/hello/removed.conf":   []byte("debug=false\n"),
+
+		"2.0/DEBIAN/control": []byte(`Package: hello
+Version: 2.0
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"2.0/DEBIAN/conffiles": []byte(`/etc/hello/settings.conf
+/etc/hello/untouched.conf
+/etc/hello/same.conf
+/etc/hello/removed.conf
+`),
+		"2.0/etc/hello/settings.conf":  []byte("lang=en\nverbose=false\n"),
+		"2.0/etc/hello/untouched.conf": []byte("color=red\n"),
+		"2.0/etc/hello/same.conf":      []byte("theme=dark\n"),
+		"2.0/etc/hello/removed.conf":   []byte("debug=true\n"),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	for _, version := range []string{"1.0", "2.0"} {
+		dpkg.Build(filepath.Join(testdir, version), filepath.Join(testdir, version+".deb"))
+	}
+	defer func() {
+		dpkg.RootDir = "/"
+		dpkg.Stdin = os.Stdin
+		dpkg.ForceConfOld, dpkg.ForceConfNew, dpkg.ForceConfDef = false, false, false
+	}()
+
+	for _, tt := range []struct {
+		name     string
+		answers  string
+		confold  bool
+		confnew  bool
+		confdef  bool
+		install  bool   // The new version of settings.conf is installed
+		expected string // Expected output
+	}{
+		{name: "keep", answers: "D\nN\n", expected: "+verbose=false"},
+		{name: "install", answers: "y\n", install: true, expected: "*** settings.conf (Y/I/N/O/D) [default=N] ?"},
+		{name: "no answer", answers: "", expected: "The default action is to keep your current version."},
+		{name: "confold", confold: true, expected: "Keeping old config file as you requested."},
+		{name: "confnew", confnew: true, install: true, expected: "Using new config file as you requested."},
+		{name: "confdef", confdef: true, expected: "Keeping old config file as default."},
+		{name: "confdef and confnew", confdef: true, confnew: true, install: true, expected: "Using new config file as you requested."},
+		{name: "confdef and confold", confdef: true, confold: true, expected: "Keeping old config file as you requested."},
+	} {
+		dir := filepath.Join(testdir, strings.ReplaceAll(tt.name, " ", "-"))
+		root := filepath.Join(dir, "root")
+		testutil.PopulateTestDir(t, dir, map[string][]byte{
+			"dpkg/status": []byte(``),
+		})
+		if err := os.MkdirAll(filepath.Join(dir, "dpkg/info"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		dpkg.VarDir = filepath.Join(dir, "dpkg")
+		dpkg.RootDir = root
+		dpkg.ForceConfOld, dpkg.ForceConfNew, dpkg.ForceConfDef = tt.confold, tt.confnew, tt.confdef
+		dpkg.Stdin = strings.NewReader(tt.answers)
+
+		// Edit the conffiles after the installation
+		dpkg.Install([]string{filepath.Join(testdir, "1.0.deb")})
+		testutil.PopulateTestDir(t, root, map[string][]byte{
+			"etc/hello/settings.conf": []byte("lang=fr\n"),
+			"etc/hello/same.conf":     []byte("theme=light\n"),
+		})
+		if err := os.Remove(filepath.Join(root, "etc/hello/removed.conf")); err != nil {
+			t.Fatal(err)
+		}
+
+		output := testutil.CaptureStdout(t, func() {
+			dpkg.Install([]string{filepath.Join(testdir, "2.0.deb")})
+		})
+		if !strings.Contains(output, tt.expected) {
+			t.Errorf("%s: missing %q in output:\n%s", tt.name, tt.expected, output)
+		}
+
+		// Conflicting changes
+		if tt.install {
+			testutil.CheckFileContains(t, filepath.Join(root, "etc/hello/settings.conf"), "lang=en\nverbose=false\n")
+			testutil.CheckFileContains(t, filepath.Join(root, "etc/hello/settings.conf.dpkg-old"), "lang=fr\n")
+		} else {
+			testutil.CheckFileContains(t, filepath.Join(root, "etc/hello/settings.conf"), "lang=fr\n")
+			testutil.CheckFileContains(t, filepath.Join(root, "etc/hello/settings.conf.dpkg-dist"), "lang=en\nverbose=false\n")
+		}
+		// Files not modified locally are upgraded
+		testutil.CheckFileContains(t, filepath.Join(root, "etc/hello/untouched.conf"), "color=red\n")
+		// Local changes are kept when the package version did not change
+		testutil.CheckFileContains(t, filepath.Join(root, "etc/hello/same.conf"), "theme=light\n")
+		// Deleted files are not recreated
+		if _, err := os.Stat(filepath.Join(root, "etc/hello/removed.conf")); !os.IsNotExist(err) {
+			t.Errorf("%s: conffile /etc/hello/removed.conf must not be recreated", tt.name)
+		}
+		checkNoTemporaryFiles(t, root)
+
+		// The hashes of the new versions are recorded
+		testutil.CheckFileContains(t, filepath.Join(dir, "dpkg/status"), `Package: hello
+Status: install ok installed
+Version: 2.0
+Architecture: all
+Maintainer: Julien Sobczak
+Installed-Size: 6
+Description: Say Hello
+Conffiles:
+ `+conffileHash("/etc/hello/removed.conf", "debug=true\n")+`
+ `+conffileHash("/etc/hello/same.conf", "theme=dark\n")+`
+ `+conffileHash("/etc/hello/settings.conf", "lang=en\nverbose=false\n")+`
+ `+conffileHash("/etc/hello/untouched.conf", "color=red\n")+`
+`)
+	}
+}
+
+/* Test Helpers */
+
+// conffileHash formats a line of the field Conffiles.
+func conffileHash(path string, content string) string {
+	return fmt.Sprintf("%s %x", path, md5.Sum([]byte(content)))
+}
@@ -1,9 +1,13 @@
 package dpkg
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/julien-sobczak/deb822"
@@ -23,13 +27,14 @@ type Status struct {
 	Content deb822.Document
 }
 
-func Load() (*Directory, error) {
-	// Load the status file
+// LoadStatus parses the status file including the changes still in the journal.
+func LoadStatus() (*deb822.Document, error) {
 	statusPath := filepath.Join(VarDir, "status")
 	f, err := os.Open(statusPath)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 	parser, err := deb822.NewParser(f)
 	if err != nil {
 		return nil, err
@@ -38,8 +43,22 @@ func Load() (*Directory, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	// Replay the changes not yet written in the status file
+	if err := replayUpdates(&statusContent); err != nil {
+		return nil, err
+	}
+	return &statusContent, nil
+}
+
+func Load() (*Directory, error) {
+	// Load the status file
+	statusContent, err := LoadStatus()
+	if err != nil {
+		return nil, err
+	}
 	status := Status{
-		Content: statusContent,
+		Content: *statusContent,
 	}
 
 	// Read the info directory
@@ -126,6 +145,36 @@ func Load() (*Directory, error) {
 	}, nil
 }
 
+// GetPackage returns the package with the given name or nil if unknown.
+func (d *Directory) GetPackage(name string) *PackageInfo {
+	for _, pkg := range d.Packages {
+		if pkg.Name() == name {
+			return pkg
+		}
+	}
+	return nil
+}
+
+// RemovePackage forgets about a package.
+func (d *Directory) RemovePackage(pkg *PackageInfo) {
+	for i, p := range d.Packages {
+		if p == pkg {
+			d.Packages = append(d.Packages[:i], d.Packages[i+1:]...)
+			return
+		}
+	}
+}
+
+// ReplacePackage substitutes a package with a new version.
+func (d *Directory) ReplacePackage(old *PackageInfo, new *PackageInfo) {
+	for i, p := range d.Packages {
+		if p == old {
+			d.Packages[i] = new
+			return
+		}
+	}
+}
+
 func (d *Directory) InstalledFiles() int {
 	count := 0
 	for _, pkg := range d.Packages {
@@ -136,6 +185,7 @@ func (d *Directory) InstalledFiles() int {
 	return count
 }
 
+// Sync writes the info files of modified packages and checkpoints the status file.
 func (d *Directory) Sync() error {
 	newStatus := deb822.Document{
 		Paragraphs: []deb822.Paragraph{},
@@ -145,23 +195,194 @@ func (d *Directory) Sync() error {
 		newStatus.Paragraphs = append(newStatus.Paragraphs, pkg.Paragraph)
 
 		if pkg.StatusDirty {
-			if err := pkg.Sync(); err != nil {
+			if err := pkg.syncInfo(); err != nil {
 				return err
 			}
 		}
 	}
 
-	os.Rename(filepath.Join(VarDir, "status"), filepath.Join(VarDir, "status-old"))
+	// Write the new status file atomically while keeping a backup of the previous one
+	statusPath := filepath.Join(VarDir, "status")
+	statusNewPath := filepath.Join(VarDir, "status-new")
+	statusOldPath := filepath.Join(VarDir, "status-old")
+	if err := writeFileSync(statusNewPath, []byte(formatStatus(newStatus)), 0644); err != nil {
+		return err
+	}
+	if _, err := os.Stat(statusPath); err == nil {
+		if err := os.Remove(statusOldPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Link(statusPath, statusOldPath); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(statusNewPath, statusPath); err != nil {
+		return err
+	}
+	if err := syncDir(VarDir); err != nil {
+		return err
+	}
+
+	// The journal is now included in the status file
+	return clearUpdates()
+}
+
+// formatStatus formats a document in the format of the status file.
+func formatStatus(doc deb822.Document) string {
 	formatter := deb822.NewFormatter()
 	formatter.SetFoldedFields("Description")
 	formatter.SetMultilineFields("Conffiles")
-	if err := os.WriteFile(filepath.Join(VarDir, "status"), []byte(formatter.Format(newStatus)), 0644); err != nil {
+	return formatter.Format(doc)
+}
+
+/*
+ * Journal
+ *
+ * Like dpkg, every change to a package is recorded in a new file under
+ * /var/lib/dpkg/updates/ (0000, 0001, ...) before the status file is rewritten.
+ * The journal is replayed when loading the database after a crash.
+ */
+
+func updatesDir() string {
+	return filepath.Join(VarDir, "updates")
+}
+
+// recordUpdate appends the status paragraph of a package to the journal.
+func recordUpdate(paragraph deb822.Paragraph) error {
+	dir := updatesDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
+	entries, err := updateEntries()
+	if err != nil {
+		return err
+	}
+	next := 0
+	if len(entries) > 0 {
+		last, _ := strconv.Atoi(entries[len(entries)-1])
+		next = last + 1
+	}
 
+	doc := deb822.Document{
+		Paragraphs: []deb822.Paragraph{paragraph},
+	}
+	tmpPath := filepath.Join(dir, "tmp.i")
+	if err := writeFileSync(tmpPath, []byte(formatStatus(doc)), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(dir, fmt.Sprintf("%04d", next))); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// updateEntries returns the names of the journal entries in order.
+func updateEntries() ([]string, error) {
+	files, err := os.ReadDir(updatesDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, file := range files {
+		if _, err := strconv.Atoi(file.Name()); err != nil {
+			// Ignore temporary files (ex: tmp.i)
+			continue
+		}
+		names = append(names, file.Name())
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, _ := strconv.Atoi(names[i])
+		b, _ := strconv.Atoi(names[j])
+		return a < b
+	})
+	return names, nil
+}
+
+// replayUpdates applies the journal entries on the status document.
+func replayUpdates(status *deb822.Document) error {
+	entries, err := updateEntries()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(updatesDir(), entry))
+		if err != nil {
+			return err
+		}
+		parser, err := deb822.NewParser(strings.NewReader(string(content)))
+		if err != nil {
+			return err
+		}
+		doc, err := parser.Parse()
+		if err != nil {
+			return fmt.Errorf("corrupted journal entry %s: %v", entry, err)
+		}
+		for _, paragraph := range doc.Paragraphs {
+			replaced := false
+			for i, current := range status.Paragraphs {
+				if current.Value("Package") == paragraph.Value("Package") {
+					status.Paragraphs[i] = paragraph
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				status.Paragraphs = append(status.Paragraphs, paragraph)
+			}
+		}
+	}
 	return nil
 }
 
+// clearUpdates removes all journal entries.
+func clearUpdates() error {
+	entries, err := updateEntries()
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := os.Remove(filepath.Join(updatesDir(), entry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileSync writes a file and flushes it to disk.
+func writeFileSync(path string, data []byte, perm os.FileMode) error {
+	return copyFileSync(path, bytes.NewReader(data), perm)
+}
+
+// copyFileSync streams the content of a file and flushes it to disk.
+func copyFileSync(path string, content io.Reader, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir flushes a directory to make renames durable.
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
 func ParseList(content string) ([]string, error) {
 	var files []string
 	for _, line := range strings.Split(content, "\n") {
@@ -0,0 +1,84 @@
+package dpkg_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestJournal(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"dpkg/status": []byte(`Package: hello
+Status: install ok installed
+Version: 1.0-1
+Architecture: all
+Description: Say Hello
+`),
+		"dpkg/info/hello.list": []byte(`/usr/bin/hello
+`),
+		"dpkg/info/world.list": []byte(`/usr/bin/world
+`),
+		// Changes recorded before a crash
+		"dpkg/updates/0000": []byte(`Package: hello
+Status: install ok half-configured
+Version: 1.1-1
+Architecture: all
+Description: Say Hello
+`),
+		"dpkg/updates/0001": []byte(`Package: world
+Status: install ok unpacked
+Version: 1.0-1
+Architecture: all
+Description: Print the world
+`),
+		// Incomplete entry
+		"dpkg/updates/tmp.i": []byte(`Package: hel`),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+
+	// The journal is replayed
+	db, err := dpkg.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(db.Packages) != 2 {
+		t.Fatalf("Unexpected packages: %v", db.Packages)
+	}
+	checkStatus(t, "hello", "half-configured")
+	checkStatus(t, "world", "unpacked")
+
+	// The journal is merged in the status file
+	if err := db.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckFileContains(t, filepath.Join(testdir, "dpkg/status"), `Package: hello
+Status: install ok half-configured
+Version: 1.1-1
+Architecture: all
+Description: Say Hello
+
+Package: world
+Status: install ok unpacked
+Version: 1.0-1
+Architecture: all
+Description: Print the world
+`)
+	testutil.CheckFileContains(t, filepath.Join(testdir, "dpkg/status-old"), string(testfiles["dpkg/status"]))
+	for _, name := range []string{"status-new", "updates/0000", "updates/0001"} {
+		if _, err := os.Stat(filepath.Join(testdir, "dpkg", name)); !os.IsNotExist(err) {
+			t.Errorf("File %s must be removed", name)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package dpkg
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// DependencyLoop lists the packages of a dependency loop being installed (set by apt).
+// Their dependencies on each other are ignored when configuring them, as a Pre-Depends
+// inside the loop requires configuring a package before the others are unpacked.
+var DependencyLoop = make(map[string]bool)
+
+// Configure configures unpacked packages.
+// When pending is true, all packages unpacked or half-configured are configured.
+// An error is returned when a package cannot be configured.
+func Configure(pkgNames []string, pending bool) error {
+	// Lock the database
+	lock, err := LockDatabase()
+	if err != nil {
+		fmt.Printf("dpkg: error: %s\n", err)
+		os.Exit(1)
+	}
+	defer lock.Release()
+
+	// Read the database
+	db, err := Load()
+	if err != nil {
+		fmt.Printf("Unable to read the database: %v", err)
+		os.Exit(1)
+	}
+
+	var pkgs []*PackageInfo
+	if pending {
+		for _, pkg := range db.Packages {
+			if pkg.Status == "unpacked" || pkg.Status == "half-configured" {
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	var invalid []string
+	for _, pkgName := range pkgNames {
+		pkg := db.GetPackage(pkgName)
+		switch {
+		case pkg == nil || pkg.Status == "not-installed" || pkg.Status == "config-files":
+			fmt.Printf("dpkg: error processing package %s (--configure):\n package %s is not installed\n", pkgName, pkgName)
+			invalid = append(invalid, pkgName)
+		case pkg.Status == "installed":
+			fmt.Printf("dpkg: error processing package %s (--configure):\n package %s is already installed and configured\n", pkgName, pkgName)
+			invalid = append(invalid, pkgName)
+		default:
+			pkgs = append(pkgs, pkg)
+		}
+	}
+
+	if err := configurePackages(db, pkgs); err != nil {
+		return err
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("errors were encountered while processing %s", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// configurePackages configures the packages, their dependencies first.
+// An error lists the packages left unconfigured.
+func configurePackages(db *Directory, pkgs []*PackageInfo) error {
+	// Packages configured in this run can satisfy dependencies (ex: cycles)
+	configuring := make(map[string]bool)
+	for _, pkg := range pkgs {
+		configuring[pkg.Name()] = true
+	}
+
+	failed := make(map[string]bool)
+	var errors []string
+	for _, pkg := range configurationOrder(pkgs) {
+		// Do not configure a package when a dependency is not configured
+		if relation, problem := db.unsatisfiedDependency(pkg, configuring); relation != "" {
+			fmt.Printf("dpkg: dependency problems prevent configuration of %s:\n %s depends on %s; however:\n  %s\n", pkg.Name(), pkg.Name(), relation, problem)
+			fmt.Printf("dpkg: error processing package %s (--configure):\n dependency problems - leaving unconfigured\n", pkg.Name())
+			failed[pkg.Name()] = true
+			delete(configuring, pkg.Name())
+			errors = append(errors, pkg.Name())
+			continue
+		}
+
+		if err := pkg.Configure(); err != nil {
+			fmt.Printf("dpkg: error processing package %s (--configure):\n %s\n", pkg.Name(), err)
+			failed[pkg.Name()] = true
+			delete(configuring, pkg.Name())
+			errors = append(errors, pkg.Name())
+		}
+		db.Sync()
+	}
+
+	if len(errors) > 0 {
+		fmt.Printf("Errors were encountered while processing:\n")
+		for _, name := range errors {
+			fmt.Printf("\t%s\n", name)
+		}
+		return fmt.Errorf("errors were encountered while processing %s", strings.Join(errors, ", "))
+	}
+	return nil
+}
+
+// unsatisfiedDependency returns the first Pre-Depends or Depends relation of the package
+// not satisfied by the configured packages, with the problem of its last alternative.
+// Ex: "libhello (>= 1.0)", "Package libhello is not configured yet."
+func (d *Directory) unsatisfiedDependency(pkg *PackageInfo, configuring map[string]bool) (string, string) {
+	for _, field := range []string{"Pre-Depends", "Depends"} {
+		value := strings.TrimSpace(pkg.Paragraph.Value(field))
+		if value == "" {
+			continue
+		}
+		for _, entry := range strings.Split(value, ",") {
+			var problem string
+			satisfied := false
+			for _, alternative := range strings.Split(entry, "|") {
+				res := relationRegex.FindStringSubmatch(strings.TrimSpace(alternative))
+				if res == nil {
+					continue
+				}
+				name := res[relationRegex.SubexpIndex("name")]
+				relation := res[relationRegex.SubexpIndex("relation")]
+				version := res[relationRegex.SubexpIndex("version")]
+
+				target := d.GetPackage(name)
+				switch {
+				case DependencyLoop[pkg.Name()] && DependencyLoop[name]:
+					satisfied = true
+				case target == nil || target.Status == "not-installed" || target.Status == "config-files":
+					if version == "" && d.provided(name) {
+						satisfied = true
+					} else {
+						problem = fmt.Sprintf("Package %s is not installed.", name)
+					}
+				case version != "" && !relationSatisfiedBy(target.Version(), relation, version):
+					problem = fmt.Sprintf("Version of %s on system is %s.", name, target.Version())
+				case target.Status == "installed" || configuring[name]:
+					satisfied = true
+				default:
+					problem = fmt.Sprintf("Package %s is not configured yet.", name)
+				}
+				if satisfied {
+					break
+				}
+			}
+			if !satisfied && problem != "" {
+				return strings.TrimSpace(entry), problem
+			}
+		}
+	}
+	return "", ""
+}
+
+// provided returns true if an installed package provides the virtual package.
+func (d *Directory) provided(name string) bool {
+	for _, pkg := range d.Packages {
+		if pkg.Status != "installed" {
+			continue
+		}
+		for _, entry := range strings.Split(pkg.Paragraph.Value("Provides"), ",") {
+			if relationNameRegex.FindString(strings.TrimSpace(entry)) == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// relationSatisfiedBy returns true if the version satisfies the relation (ex: ">=" "1.0").
+func relationSatisfiedBy(version string, relation string, reference string) bool {
+	cmp := CompareVersions(version, reference)
+	switch relation {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">>":
+		return cmp > 0
+	case "<<":
+		return cmp < 0
+	default: // =
+		return cmp == 0
+	}
+}
+
+// configurationOrder sorts the packages so that dependencies are configured first.
+// Dependency cycles are broken arbitrarily.
+func configurationOrder(pkgs []*PackageInfo) []*PackageInfo {
+	byName := make(map[string]*PackageInfo)
+	for _, pkg := range pkgs {
+		byName[pkg.Name()] = pkg
+	}
+
+	var ordered []*PackageInfo
+	visited := make(map[string]bool)
+	var visit func(pkg *PackageInfo)
+	visit = func(pkg *PackageInfo) {
+		if visited[pkg.Name()] {
+			return
+		}
+		visited[pkg.Name()] = true
+		for _, dep := range pkg.Depends() {
+			if target, ok := byName[dep]; ok {
+				visit(target)
+			}
+		}
+		ordered = append(ordered, pkg)
+	}
+	for _, pkg := range pkgs {
+		visit(pkg)
+	}
+	return ordered
+}
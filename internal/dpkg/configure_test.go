@@ -0,0 +1,183 @@
+package dpkg_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestUnpackConfigure(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello/DEBIAN/control": []byte(`Package: hello
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: libhello (>= 1.0)
+Description: Say Hello
+`),
+		"hello/DEBIAN/postinst": []byte(`#!/bin/sh
+echo "hello $@" >> ` + testdir + `/postinst.log
+`),
+		"hello/usr/bin/hello": []byte(`hello`),
+		"libhello/DEBIAN/control": []byte(`Package: libhello
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Hello library
+`),
+		// Fail until the network is "up"
+		"libhello/DEBIAN/postinst": []byte(`#!/bin/sh
+test -f ` + testdir + `/network || exit 1
+echo "libhello $@" >> ` + testdir + `/postinst.log
+`),
+		"libhello/usr/lib/libhello.so": []byte(`library`),
+
+		"dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	if err := os.MkdirAll(filepath.Join(testdir, "dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var archives []string
+	for _, pkgdir := range []string{"hello", "libhello"} {
+		archive := filepath.Join(testdir, pkgdir+".deb")
+		dpkg.Build(filepath.Join(testdir, pkgdir), archive)
+		archives = append(archives, archive)
+	}
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = testdir
+	defer func() { dpkg.RootDir = "/" }()
+
+	// Unpack only
+	dpkg.Unpack(archives)
+	testutil.CheckFileExists(t, filepath.Join(testdir, "usr/bin/hello"))
+	checkStatus(t, "hello", "unpacked")
+	checkStatus(t, "libhello", "unpacked")
+
+	// libhello fails to configure and hello must wait for it
+	dpkg.Configure(nil, true)
+	checkStatus(t, "libhello", "half-configured")
+	checkStatus(t, "hello", "unpacked")
+
+	// Resume the configuration
+	if err := ioutil.WriteFile(filepath.Join(testdir, "network"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	dpkg.Configure(nil, true)
+	checkStatus(t, "libhello", "installed")
+	checkStatus(t, "hello", "installed")
+	testutil.CheckFileContains(t, filepath.Join(testdir, "postinst.log"), `libhello configure
+hello configure
+`)
+
+	// Upgrades pass the last configured version to postinst
+	dpkg.Unpack(archives[:1])
+	checkStatus(t, "hello", "unpacked")
+	dpkg.Configure([]string{"hello"}, false)
+	checkStatus(t, "hello", "installed")
+	testutil.CheckFileContains(t, filepath.Join(testdir, "postinst.log"), `libhello configure
+hello configure
+hello configure 1.1-1
+`)
+}
+
+func TestConfigureDependencyProblems(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello/DEBIAN/control": []byte(`Package: hello
+Version: 1.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: libhello (>= 1.0)
+Description: Say Hello
+`),
+		"hello/usr/bin/hello": []byte(`hello`),
+		"libhello/DEBIAN/control": []byte(`Package: libhello
+Version: 1.0-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Hello library
+`),
+		"libhello/usr/lib/libhello.so": []byte(`library`),
+
+		"dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	if err := os.MkdirAll(filepath.Join(testdir, "dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var archives []string
+	for _, pkgdir := range []string{"hello", "libhello"} {
+		archive := filepath.Join(testdir, pkgdir+".deb")
+		dpkg.Build(filepath.Join(testdir, pkgdir), archive)
+		archives = append(archives, archive)
+	}
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = testdir
+	defer func() { dpkg.RootDir = "/" }()
+
+	// The dependency is not installed
+	output := testutil.CaptureStdout(t, func() { dpkg.Install(archives[:1]) })
+	if !strings.Contains(output, `dpkg: dependency problems prevent configuration of hello:
+ hello depends on libhello (>= 1.0); however:
+  Package libhello is not installed.
+`) {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+	checkStatus(t, "hello", "unpacked")
+
+	// The dependency is only unpacked
+	dpkg.Unpack(archives[1:])
+	checkStatus(t, "libhello", "unpacked")
+	output = testutil.CaptureStdout(t, func() { dpkg.Configure([]string{"hello"}, false) })
+	if !strings.Contains(output, `dpkg: dependency problems prevent configuration of hello:
+ hello depends on libhello (>= 1.0); however:
+  Package libhello is not configured yet.
+`) {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+	checkStatus(t, "hello", "unpacked")
+	checkStatus(t, "libhello", "unpacked")
+
+	// The dependency is configured
+	dpkg.Configure([]string{"libhello", "hello"}, false)
+	checkStatus(t, "libhello", "installed")
+	checkStatus(t, "hello", "installed")
+}
+
+/* Test Helpers */
+
+func checkStatus(t *testing.T, pkgName string, expected string) {
+	db, err := dpkg.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := db.GetPackage(pkgName)
+	if pkg == nil {
+		t.Fatalf("Missing package %s", pkgName)
+	}
+	if pkg.Status != expected {
+		t.Errorf("Unexpected status for %s: got %q, expected %q", pkgName, pkg.Status, expected)
+	}
+	if expected == "installed" && pkg.Paragraph.Value("Config-Version") != "" {
+		t.Errorf("Unexpected Config-Version for installed package %s", pkgName)
+	}
+}
@@ -0,0 +1,212 @@
+package dpkg
+
+import (
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/julien-sobczak/deb822"
+)
+
+// mandatoryFields lists the fields required in a binary package control file.
+var mandatoryFields = []string{"Package", "Version", "Architecture", "Maintainer", "Description"}
+
+// relationFields lists the fields containing package relationships.
+var relationFields = []string{"Pre-Depends", "Depends", "Recommends", "Suggests", "Enhances", "Breaks", "Conflicts", "Provides", "Replaces"}
+
+var (
+	packageNameRegex  = regexp.MustCompile(`^[a-z0-9][a-z0-9.+-]+$`)
+	architectureRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+	relationRegex     = regexp.MustCompile(`^(?P<name>[^\s:(\[<]+)(?::[a-z0-9-]+)?(?:\s*\(\s*(?P<relation><<|<=|=|>=|>>)\s*(?P<version>[^\s)]+)\s*\))?(?:\s*\[[^\]]+\])?(?:\s*<[^>]+>)*$`)
+)
+
+// ControlError reports an invalid field in a control file.
+type ControlError struct {
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e *ControlError) Error() string {
+	if e.Value == "" {
+		return fmt.Sprintf("'%s' field: %s", e.Field, e.Reason)
+	}
+	return fmt.Sprintf("'%s' field value '%s': %s", e.Field, e.Value, e.Reason)
+}
+
+// ValidateControl checks the fields of a binary package control file.
+// All problems are reported, each one as a *ControlError.
+func ValidateControl(paragraph deb822.Paragraph) error {
+	var errs []error
+	for _, field := range mandatoryFields {
+		if strings.TrimSpace(paragraph.Value(field)) == "" {
+			errs = append(errs, &ControlError{Field: field, Reason: "missing mandatory field"})
+		}
+	}
+
+	if name := paragraph.Value("Package"); name != "" && !packageNameRegex.MatchString(name) {
+		errs = append(errs, &ControlError{Field: "Package", Value: name, Reason: "invalid package name (must match [a-z0-9][a-z0-9.+-]+)"})
+	}
+	if version := paragraph.Value("Version"); version != "" {
+		if err := ValidateVersion(version); err != nil {
+			errs = append(errs, &ControlError{Field: "Version", Value: version, Reason: err.Error()})
+		}
+	}
+	if arch := paragraph.Value("Architecture"); arch != "" && !architectureRegex.MatchString(arch) {
+		errs = append(errs, &ControlError{Field: "Architecture", Value: arch, Reason: "invalid architecture name"})
+	}
+
+	for _, field := range relationFields {
+		value := strings.TrimSpace(paragraph.Value(field))
+		if value == "" {
+			continue
+		}
+		for _, entry := range strings.Split(value, ",") {
+			for _, alternative := range strings.Split(entry, "|") {
+				if err := validateRelation(strings.TrimSpace(alternative)); err != nil {
+					errs = append(errs, &ControlError{Field: field, Value: value, Reason: err.Error()})
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateRelation checks a single relation like "libc6 (>= 2.15)".
+func validateRelation(value string) error {
+	if value == "" {
+		return fmt.Errorf("empty package name in relation")
+	}
+	res := relationRegex.FindStringSubmatch(value)
+	if res == nil {
+		return fmt.Errorf("invalid syntax in relation '%s'", value)
+	}
+	name := res[relationRegex.SubexpIndex("name")]
+	if !packageNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid package name '%s' in relation", name)
+	}
+	if version := res[relationRegex.SubexpIndex("version")]; version != "" {
+		if err := ValidateVersion(version); err != nil {
+			return fmt.Errorf("invalid version '%s' in relation: %v", version, err)
+		}
+	}
+	return nil
+}
+
+// generateControlFiles returns the control files computed from the package content:
+// the control file completed with Installed-Size and the md5sums file.
+func generateControlFiles(directory string) (map[string][]byte, error) {
+	controlPath := filepath.Join(directory, "DEBIAN", "control")
+	f, err := os.Open(controlPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	parser, err := deb822.NewParser(f)
+	if err != nil {
+		return nil, err
+	}
+	document, err := parser.Parse()
+	if err != nil {
+		return nil, fmt.Errorf("parsing file '%s': %v", controlPath, err)
+	}
+	if len(document.Paragraphs) != 1 {
+		return nil, fmt.Errorf("parsing file '%s': expected a single paragraph", controlPath)
+	}
+	control := document.Paragraphs[0]
+	if err := ValidateControl(control); err != nil {
+		return nil, fmt.Errorf("parsing file '%s' package '%s':\n%v", controlPath, control.Value("Package"), err)
+	}
+
+	conffiles := make(map[string]bool)
+	if content, err := os.ReadFile(filepath.Join(directory, "DEBIAN", "conffiles")); err == nil {
+		names, _ := ParseConffiles(string(content))
+		for _, name := range names {
+			conffiles[name] = true
+		}
+	}
+
+	// Compute checksums and disk usage
+	checksums := make(map[string]string)
+	var installedSize int64 // In KiB
+	controlDir := filepath.Join(directory, "DEBIAN")
+	err = filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == controlDir {
+			return filepath.SkipDir
+		}
+		if path == directory {
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			// Directories, symlinks, etc. count as 1 KiB like dpkg-gencontrol
+			installedSize++
+			return nil
+		}
+		installedSize += (info.Size() + 1023) / 1024
+
+		name := strings.TrimPrefix(path, directory+string(filepath.Separator))
+		if conffiles["/"+name] {
+			// Conffiles are not listed like dh_md5sums does
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		hash := md5.New()
+		if _, err := io.Copy(hash, f); err != nil {
+			return err
+		}
+		checksums[name] = fmt.Sprintf("%x", hash.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	generated := make(map[string][]byte)
+
+	if control.Value("Installed-Size") == "" {
+		insertField(&control, "Installed-Size", fmt.Sprintf("%d", installedSize), "Maintainer")
+		formatter := deb822.NewFormatter()
+		formatter.SetFoldedFields("Description")
+		generated["control"] = []byte(formatter.Format(deb822.Document{Paragraphs: []deb822.Paragraph{control}}))
+	}
+
+	if len(checksums) > 0 {
+		var names []string
+		for name := range checksums {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var sb strings.Builder
+		for _, name := range names {
+			sb.WriteString(fmt.Sprintf("%s  %s\n", checksums[name], name))
+		}
+		generated["md5sums"] = []byte(sb.String())
+	}
+
+	return generated, nil
+}
+
+// insertField adds a field after another one (or at the end if missing).
+func insertField(paragraph *deb822.Paragraph, name string, value string, after string) {
+	paragraph.Values[name] = value
+	for i, field := range paragraph.Order {
+		if field == after {
+			paragraph.Order = append(paragraph.Order[:i+1], append([]string{name}, paragraph.Order[i+1:]...)...)
+			return
+		}
+	}
+	paragraph.Order = append(paragraph.Order, name)
+}
@@ -0,0 +1,80 @@
+package dpkg_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/julien-sobczak/deb822"
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+)
+
+func TestValidateControl(t *testing.T) {
+	var tests = []struct {
+		name     string
+		control  string
+		expected []string
+	}{
+		{
+			name: "valid",
+			control: `Package: hello
+Version: 2.10-2
+Architecture: amd64
+Maintainer: Santiago Vila <sanvila@debian.org>
+Pre-Depends: dpkg (>= 1.15.6~)
+Depends: libc6 (>= 2.14), debconf | debconf-2.0, perl:any
+Description: example package based on GNU hello
+`,
+		},
+		{
+			name: "missing fields",
+			control: `Package: hello
+Version: 2.10-2
+`,
+			expected: []string{
+				"'Architecture' field: missing mandatory field",
+				"'Maintainer' field: missing mandatory field",
+				"'Description' field: missing mandatory field",
+			},
+		},
+		{
+			name: "invalid values",
+			control: `Package: Hello_World
+Version: v2.10
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: libc6 (>= 2.14, libhello (=> 1.0)
+Description: Say Hello
+`,
+			expected: []string{
+				"'Package' field value 'Hello_World': invalid package name (must match [a-z0-9][a-z0-9.+-]+)",
+				"'Version' field value 'v2.10': version number does not start with digit",
+				"'Depends' field value 'libc6 (>= 2.14, libhello (=> 1.0)': invalid syntax in relation 'libc6 (>= 2.14'",
+				"'Depends' field value 'libc6 (>= 2.14, libhello (=> 1.0)': invalid syntax in relation 'libhello (=> 1.0)'",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		parser, err := deb822.NewParser(strings.NewReader(tt.control))
+		if err != nil {
+			t.Fatal(err)
+		}
+		document, err := parser.Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = dpkg.ValidateControl(document.Paragraphs[0])
+		var actual []string
+		if err != nil {
+			actual = strings.Split(err.Error(), "\n")
+		}
+		if strings.Join(actual, "\n") != strings.Join(tt.expected, "\n") {
+			t.Errorf("Unexpected errors for %s:\n%s", tt.name, strings.Join(actual, "\n"))
+		}
+		var controlErr *dpkg.ControlError
+		if err != nil && !errors.As(err, &controlErr) {
+			t.Errorf("Expected a ControlError for %s", tt.name)
+		}
+	}
+}
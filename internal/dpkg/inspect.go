@@ -0,0 +1,307 @@
+package dpkg
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/julien-sobczak/deb822"
+)
+
+// Info prints the members of an archive and the control file (like dpkg-deb --info).
+func Info(archivePath string) {
+	output, err := archiveInfo(archivePath)
+	if err != nil {
+		fmt.Printf("dpkg-deb: error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(output)
+}
+
+func archiveInfo(archivePath string) (string, error) {
+	archive, err := OpenArchive(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+	stat, err := os.Stat(archivePath)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	header, err := archive.NextMember("control.tar")
+	if err != nil {
+		return "", err
+	}
+	control, err := archive.Tarball()
+	if err != nil {
+		return "", err
+	}
+	sb.WriteString(fmt.Sprintf(" new Debian package, version %s.\n", archive.Version))
+	sb.WriteString(fmt.Sprintf(" size %d bytes: control archive=%d bytes.\n", stat.Size(), header.Size))
+
+	var controlFile string
+	for {
+		hdr, err := control.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		var buf bytes.Buffer
+		if _, err := io.Copy(&buf, control); err != nil {
+			return "", err
+		}
+		name := strings.TrimPrefix(hdr.Name, "./")
+		if name == "control" {
+			controlFile = buf.String()
+		}
+
+		// Ex: 38 bytes, 2 lines * postinst #!/bin/sh
+		executable := ' '
+		if hdr.Mode&0111 != 0 {
+			executable = '*'
+		}
+		var interpreter string
+		if firstLine := strings.SplitN(buf.String(), "\n", 2)[0]; strings.HasPrefix(firstLine, "#!") {
+			interpreter = firstLine
+		}
+		line := fmt.Sprintf(" %7d bytes, %5d lines   %c  %-20s %s", hdr.Size, strings.Count(buf.String(), "\n"), executable, name, interpreter)
+		sb.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+
+	for _, line := range strings.SplitAfter(controlFile, "\n") {
+		if line == "" {
+			continue
+		}
+		sb.WriteString(" " + line)
+	}
+	return sb.String(), nil
+}
+
+// Contents lists the files in an archive (like dpkg-deb --contents).
+func Contents(archivePath string) {
+	output, err := archiveContents(archivePath)
+	if err != nil {
+		fmt.Printf("dpkg-deb: error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(output)
+}
+
+func archiveContents(archivePath string) (string, error) {
+	archive, err := OpenArchive(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+	if _, err := archive.NextMember("data.tar"); err != nil {
+		return "", err
+	}
+	tr, err := archive.Tarball()
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		sb.WriteString(listEntry(hdr))
+		sb.WriteString("\n")
+	}
+	return sb.String(), nil
+}
+
+// listEntry formats a tar entry like tar -tv does.
+// Ex: -rwxr-xr-x root/root      1234 2021-05-15 10:00 ./usr/bin/hello
+func listEntry(hdr *tar.Header) string {
+	owner := hdr.Uname
+	if owner == "" {
+		owner = fmt.Sprintf("%d", hdr.Uid)
+	}
+	group := hdr.Gname
+	if group == "" {
+		group = fmt.Sprintf("%d", hdr.Gid)
+	}
+	res := fmt.Sprintf("%s %s/%s %9d %s %s",
+		modeString(hdr), owner, group, hdr.Size, hdr.ModTime.Local().Format("2006-01-02 15:04"), "./"+strings.TrimPrefix(hdr.Name, "./"))
+	switch hdr.Typeflag {
+	case tar.TypeSymlink:
+		res += " -> " + hdr.Linkname
+	case tar.TypeLink:
+		res += " link to ./" + strings.TrimPrefix(hdr.Linkname, "./")
+	}
+	return res
+}
+
+// modeString returns the permissions of a tar entry like ls -l does.
+func modeString(hdr *tar.Header) string {
+	kind := map[byte]byte{
+		tar.TypeDir:     'd',
+		tar.TypeSymlink: 'l',
+		tar.TypeLink:    'h',
+		tar.TypeChar:    'c',
+		tar.TypeBlock:   'b',
+		tar.TypeFifo:    'p',
+	}[hdr.Typeflag]
+	if kind == 0 {
+		kind = '-'
+	}
+	res := []byte{kind}
+	for i, shift := range []uint{6, 3, 0} {
+		bits := hdr.Mode >> shift
+		perm := []byte("---")
+		if bits&4 != 0 {
+			perm[0] = 'r'
+		}
+		if bits&2 != 0 {
+			perm[1] = 'w'
+		}
+		if bits&1 != 0 {
+			perm[2] = 'x'
+		}
+		// Special bits replace the execute permission (setuid, setgid, sticky)
+		if special := hdr.Mode & (04000 >> i); special != 0 {
+			letter := []byte("sst")[i]
+			if perm[2] == '-' {
+				letter -= 'a' - 'A'
+			}
+			perm[2] = letter
+		}
+		res = append(res, perm...)
+	}
+	return string(res)
+}
+
+// Field prints the control file or some of its fields (like dpkg-deb --field).
+func Field(archivePath string, fields []string) {
+	output, err := archiveField(archivePath, fields)
+	if err != nil {
+		fmt.Printf("dpkg-deb: error: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Print(output)
+}
+
+func archiveField(archivePath string, fields []string) (string, error) {
+	archive, err := OpenArchive(archivePath)
+	if err != nil {
+		return "", err
+	}
+	defer archive.Close()
+	bufControl, err := archive.ReadControl()
+	if err != nil {
+		return "", err
+	}
+	pkg, err := ParseControl(nil, bufControl)
+	if err != nil {
+		return "", err
+	}
+
+	// Remove the Status field added by ParseControl
+	control := deb822.Paragraph{Values: make(map[string]string)}
+	for _, field := range pkg.Paragraph.Order {
+		if field == "Status" {
+			continue
+		}
+		control.Order = append(control.Order, field)
+		control.Values[field] = pkg.Paragraph.Value(field)
+	}
+	formatter := deb822.NewFormatter()
+	formatter.SetFoldedFields("Description")
+	if len(fields) == 0 {
+		return formatter.Format(deb822.Document{Paragraphs: []deb822.Paragraph{control}}), nil
+	}
+
+	// Field names are case-insensitive
+	selected := deb822.Paragraph{Values: make(map[string]string)}
+	for _, name := range fields {
+		for _, field := range control.Order {
+			if strings.EqualFold(field, name) {
+				selected.Order = append(selected.Order, field)
+				selected.Values[field] = control.Value(field)
+			}
+		}
+	}
+	if len(fields) == 1 {
+		if len(selected.Order) == 0 {
+			return "", nil
+		}
+		// Print only the value
+		value := formatter.Format(deb822.Document{Paragraphs: []deb822.Paragraph{selected}})
+		return strings.TrimPrefix(value, selected.Order[0]+": "), nil
+	}
+	return formatter.Format(deb822.Document{Paragraphs: []deb822.Paragraph{selected}}), nil
+}
+
+// Extract extracts the files of an archive in a directory (like dpkg-deb --extract).
+func Extract(archivePath string, directory string) {
+	if err := extractMember(archivePath, "data.tar", directory); err != nil {
+		fmt.Printf("dpkg-deb: error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// ExtractControl extracts the control files of an archive in a directory (like dpkg-deb --control).
+func ExtractControl(archivePath string, directory string) {
+	if err := extractMember(archivePath, "control.tar", directory); err != nil {
+		fmt.Printf("dpkg-deb: error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func extractMember(archivePath string, prefix string, directory string) error {
+	archive, err := OpenArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	if _, err := archive.NextMember(prefix); err != nil {
+		return err
+	}
+	tr, err := archive.Tarball()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return err
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := entryPath(hdr.Name)
+		if name == "/" {
+			continue
+		}
+		err = extractEntry(directory, name, hdr, tr)
+		if err == errUnsupportedEntry {
+			fmt.Printf("dpkg-deb: warning: ignoring unsupported file type for %s\n", filepath.Join(directory, name))
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package dpkg_test
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andreyvit/diff"
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestInspect(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"src/DEBIAN/control": []byte(`Package: hello
+Version: 2.10-2
+Architecture: all
+Maintainer: Julien Sobczak
+Depends: libc6 (>= 2.14)
+Description: Say Hello
+ The GNU hello program produces a familiar, friendly greeting.
+`),
+		"src/DEBIAN/postinst": []byte(`#!/bin/sh
+echo "Hello installed"
+`),
+		"src/usr/bin/hello": []byte(`#!/bin/sh
+echo "Hello, world!"
+`),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	src := filepath.Join(testdir, "src")
+	for path, mode := range map[string]os.FileMode{
+		"DEBIAN/postinst": 0755,
+		"usr/bin/hello":   0755,
+	} {
+		if err := os.Chmod(filepath.Join(src, path), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("hello", filepath.Join(src, "usr/bin/hi")); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SOURCE_DATE_EPOCH", "1600000000")
+	archive := filepath.Join(testdir, "hello.deb")
+	dpkg.Build(src, archive)
+
+	// Archive API
+	a, err := dpkg.OpenArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var members []string
+	for {
+		header, err := a.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, header.Name)
+	}
+	a.Close()
+	if actual := strings.Join(members, " "); a.Version != "2.0" || actual != "control.tar.xz data.tar.xz" {
+		t.Errorf("Unexpected members in version %s: %s", a.Version, actual)
+	}
+	a, err = dpkg.OpenArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bufControl, err := a.ReadControl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := dpkg.ParseControl(nil, bufControl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg.Name() != "hello" || pkg.MaintainerScripts["postinst"] == "" {
+		t.Errorf("Unexpected control files: %v", pkg.Paragraph)
+	}
+	if _, err := a.NextMember("data.tar"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.Tarball(); err != nil {
+		t.Fatal(err)
+	}
+	a.Close()
+
+	// --info
+	info := testutil.CaptureStdout(t, func() { dpkg.Info(archive) })
+	stat, err := os.Stat(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(info, "\n")
+	if lines[0] != " new Debian package, version 2.0." || !strings.HasPrefix(lines[1], fmt.Sprintf(" size %d bytes: control archive=", stat.Size())) {
+		t.Errorf("Unexpected header:\n%s", info)
+	}
+	expected := `     205 bytes,     8 lines      control
+      33 bytes,     2 lines   *  postinst             #!/bin/sh
+      48 bytes,     1 lines      md5sums
+ Package: hello
+ Version: 2.10-2
+ Architecture: all
+ Maintainer: Julien Sobczak
+ Installed-Size: 4
+ Depends: libc6 (>= 2.14)
+ Description: Say Hello
+  The GNU hello program produces a familiar, friendly greeting.
+`
+	if actual := strings.Join(lines[2:], "\n"); actual != expected {
+		t.Errorf("Unexpected info:\n%v", diff.LineDiff(actual, expected))
+	}
+
+	// --contents
+	date := time.Unix(1600000000, 0).Local().Format("2006-01-02 15:04")
+	expected = fmt.Sprintf(`drwxr-xr-x root/root         0 %[1]s ./usr/
+drwxr-xr-x root/root         0 %[1]s ./usr/bin/
+-rwxr-xr-x root/root        31 %[1]s ./usr/bin/hello
+lrwxrwxrwx root/root         0 %[1]s ./usr/bin/hi -> hello
+`, date)
+	if actual := testutil.CaptureStdout(t, func() { dpkg.Contents(archive) }); actual != expected {
+		t.Errorf("Unexpected contents:\n%v", diff.LineDiff(actual, expected))
+	}
+
+	// --field
+	for _, tt := range []struct {
+		fields   []string
+		expected string
+	}{
+		{[]string{"version"}, "2.10-2\n"},
+		{[]string{"Package", "Depends"}, "Package: hello\nDepends: libc6 (>= 2.14)\n"},
+		{[]string{"Description"}, "Say Hello\n The GNU hello program produces a familiar, friendly greeting.\n"},
+		{[]string{"Homepage"}, ""},
+	} {
+		if actual := testutil.CaptureStdout(t, func() { dpkg.Field(archive, tt.fields) }); actual != tt.expected {
+			t.Errorf("Unexpected value for fields %v:\n%s", tt.fields, actual)
+		}
+	}
+	if actual := testutil.CaptureStdout(t, func() { dpkg.Field(archive, nil) }); !strings.HasPrefix(actual, "Package: hello\nVersion: 2.10-2\n") {
+		t.Errorf("Unexpected control file:\n%s", actual)
+	}
+
+	// --extract and --control
+	dpkg.Extract(archive, filepath.Join(testdir, "extract"))
+	testutil.CheckFileContains(t, filepath.Join(testdir, "extract/usr/bin/hello"), string(testfiles["src/usr/bin/hello"]))
+	checkMode(t, filepath.Join(testdir, "extract/usr/bin/hello"), 0755)
+	if target, err := os.Readlink(filepath.Join(testdir, "extract/usr/bin/hi")); err != nil || target != "hello" {
+		t.Errorf("Unexpected symlink target %q (%v)", target, err)
+	}
+	dpkg.ExtractControl(archive, filepath.Join(testdir, "control"))
+	testutil.CheckFileContains(t, filepath.Join(testdir, "control/postinst"), string(testfiles["src/DEBIAN/postinst"]))
+	checkMode(t, filepath.Join(testdir, "control/postinst"), 0755)
+	testutil.CheckFileContains(t, filepath.Join(testdir, "control/md5sums"), "d9ee44d59390c7097f20a0ec1c449048  usr/bin/hello\n")
+}
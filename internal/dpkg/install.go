@@ -3,19 +3,55 @@ package dpkg
 import (
 	"archive/tar"
 	"bytes"
-	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
-	"github.com/blakesmith/ar"
 	"github.com/julien-sobczak/deb822"
-	"github.com/ulikunitz/xz"
 )
 
-func Install(archiveFilepaths []string) {
+// Install unpacks and configures the archive(s).
+// An error is returned when a package cannot be installed.
+func Install(archiveFilepaths []string) error {
+	// Lock the database
+	lock, err := LockDatabase()
+	if err != nil {
+		fmt.Printf("dpkg: error: %s\n", err)
+		os.Exit(1)
+	}
+	defer lock.Release()
+
+	// Read the database
+	db, err := Load()
+	if err != nil {
+		fmt.Printf("Unable to read the database: %v", err)
+		os.Exit(1)
+	}
+	fmt.Printf("(Reading database ... %d files and directories currently installed.)\n", db.InstalledFiles())
+
+	// Unpack all archives first
+	pkgs, unpackErr := unpackArchives(db, archiveFilepaths)
+
+	// Configure them once all dependencies are present
+	if err := configurePackages(db, pkgs); err != nil {
+		return err
+	}
+	return unpackErr
+}
+
+// Unpack extracts the archive(s) without configuring the packages.
+// An error is returned when an archive cannot be unpacked.
+func Unpack(archiveFilepaths []string) error {
+	// Lock the database
+	lock, err := LockDatabase()
+	if err != nil {
+		fmt.Printf("dpkg: error: %s\n", err)
+		os.Exit(1)
+	}
+	defer lock.Release()
+
 	// Read the database
 	db, err := Load()
 	if err != nil {
@@ -24,92 +60,153 @@ func Install(archiveFilepaths []string) {
 	}
 	fmt.Printf("(Reading database ... %d files and directories currently installed.)\n", db.InstalledFiles())
 
-	// Unpack and configure the archive(s)
+	_, err = unpackArchives(db, archiveFilepaths)
+	return err
+}
+
+func unpackArchives(db *Directory, archiveFilepaths []string) ([]*PackageInfo, error) {
+	var pkgs []*PackageInfo
+	var failed []string
 	for _, archivePath := range archiveFilepaths {
-		err := processArchive(db, archivePath)
+		pkg, err := processArchive(db, archivePath)
 		if err != nil {
 			fmt.Printf("dpkg-deb: error: %s\n", err)
 			fmt.Printf("Errors were encountered while processing:\n\t%s\n", archivePath)
+			failed = append(failed, archivePath)
+			continue
 		}
+		pkgs = append(pkgs, pkg)
 	}
+	if len(failed) > 0 {
+		return pkgs, fmt.Errorf("errors were encountered while processing %s", strings.Join(failed, ", "))
+	}
+	return pkgs, nil
 }
 
-func processArchive(db *Directory, archivePath string) error {
+func processArchive(db *Directory, archivePath string) (*PackageInfo, error) {
 	// Read the debian archive file
-	f, err := os.Open(archivePath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	reader := ar.NewReader(f)
-
-	// Skip debian-binary
-	_, err = reader.Next()
+	archive, err := OpenArchive(archivePath)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer archive.Close()
 
 	// control.tar
-	header, err := reader.Next()
+	bufControl, err := archive.ReadControl()
 	if err != nil {
-		return err
-	}
-	var bufControl bytes.Buffer
-	err = extractTar(header.Name, &bufControl, reader)
-	if err != nil {
-		return err
+		return nil, err
 	}
 
 	pkg, err := ParseControl(db, bufControl)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Add new package in database
-	db.Packages = append(db.Packages, pkg)
-	db.Sync()
-
-	// data.tar
-	header, err = reader.Next()
-	if err != nil {
-		return err
+	// data.tar (located before any change to the database to reject invalid archives)
+	if _, err := archive.NextMember("data.tar"); err != nil {
+		return nil, err
 	}
-	var bufData bytes.Buffer
-	err = extractTar(header.Name, &bufData, reader)
+	data, err := archive.Tarball()
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	// Add new package in database
+	if previous := db.GetPackage(pkg.Name()); previous != nil && previous.Status != "not-installed" {
+		// Upgrade (or reinstall) the existing package
+		if err := previous.runMaintainerScript("prerm", "upgrade", pkg.Version()); err != nil {
+			// Error unwind: the old version stays installed
+			if err := previous.runMaintainerScript("postinst", "abort-upgrade", pkg.Version()); err != nil {
+				fmt.Printf("dpkg: error while cleaning up:\n %s\n", err)
+			}
+			return nil, err
+		}
+		// Remember the last configured version for postinst
+		if configVersion := previous.ConfigVersion(); configVersion != "" {
+			pkg.setField("Config-Version", configVersion)
+		}
+		// Remember the hashes of the installed conffiles to detect local modifications
+		if conffiles := previous.Paragraph.Value("Conffiles"); conffiles != "" {
+			pkg.setField("Conffiles", conffiles)
+		}
+		pkg.previous = previous
+		db.ReplacePackage(previous, pkg)
+	} else {
+		if previous != nil {
+			db.RemovePackage(previous)
+		}
+		db.Packages = append(db.Packages, pkg)
 	}
+	db.Sync()
 
 	fmt.Printf("Preparing to unpack %s ...\n", filepath.Base(archivePath))
 
-	if err := pkg.Unpack(bufData); err != nil {
-		return err
-	}
-	if err := pkg.Configure(); err != nil {
-		return err
+	if err := pkg.Unpack(data); err != nil {
+		if err := pkg.abortUnpack(db); err != nil {
+			fmt.Printf("dpkg: error while cleaning up:\n %s\n", err)
+		}
+		db.Sync()
+		return nil, err
 	}
 
 	db.Sync()
 
-	return nil
+	return pkg, nil
 }
 
-func extractTar(filename string, writer io.Writer, reader io.Reader) error {
-	if strings.HasSuffix(filename, ".gz") {
-		gzf, err := gzip.NewReader(reader)
-		if err != nil {
+// abortUnpack undoes a failed unpack following the Debian policy error unwind.
+// A new installation is forgotten. An upgrade is reverted to the old version
+// when no file was replaced, and is left half-installed otherwise.
+func (p *PackageInfo) abortUnpack(db *Directory) error {
+	if p.previous == nil {
+		fmt.Printf("Removing %s (%s) after failed installation ...\n", p.Name(), p.Version())
+		scriptErr := p.runMaintainerScript("postrm", "abort-install")
+		// Files not yet renamed are discarded, including the new conffiles
+		for _, path := range p.Files {
+			if !p.isPending(path) && !p.isConffile(path) {
+				continue
+			}
+			if err := removePath(filepath.Join(RootDir, path+".dpkg-new")); err != nil {
+				return err
+			}
+		}
+		// Only the paths created by this unpack are removed,
+		// existing files may belong to other packages
+		for _, path := range removalOrder(p.created) {
+			if err := removePath(filepath.Join(RootDir, path)); err != nil {
+				return err
+			}
+		}
+		if err := p.RemoveInfo(); err != nil {
 			return err
 		}
-		reader = gzf
-	} else if strings.HasSuffix(filename, ".xz") {
-		xzf, err := xz.NewReader(reader)
-		if err != nil {
+		db.RemovePackage(p)
+		return scriptErr
+	}
+
+	scriptErr := p.runMaintainerScript("postrm", "abort-upgrade", p.previous.Version())
+	// Files not yet renamed are discarded, the old ones are still in place
+	for _, path := range p.pending {
+		if err := removePath(filepath.Join(RootDir, path+".dpkg-new")); err != nil {
 			return err
 		}
-		reader = xzf
 	}
-	io.Copy(writer, reader)
-	return nil
+	if !p.replacedFiles() {
+		// The old files are still in place, restore the previous version
+		fmt.Printf("Restoring %s (%s) after failed upgrade ...\n", p.previous.Name(), p.previous.Version())
+		db.ReplacePackage(p, p.previous)
+		p.previous.StatusDirty = true
+	}
+	return scriptErr
+}
+
+func extractTar(filename string, writer io.Writer, reader io.Reader) error {
+	decompressed, err := Decompress(filename, reader)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, decompressed)
+	return err
 }
 
 func ParseControl(db *Directory, buf bytes.Buffer) (*PackageInfo, error) {
@@ -171,10 +268,14 @@ func ParseControl(db *Directory, buf bytes.Buffer) (*PackageInfo, error) {
 				return nil, err
 			}
 		case "md5sums":
-			pkg.MD5sums, err = ParseMD5Sums(buf.String())
+			checksums, err := ParseMD5Sums(buf.String())
 			if err != nil {
 				return nil, err
 			}
+			// Ex: usr/bin/hello => /usr/bin/hello
+			for path, checksum := range checksums {
+				pkg.MD5sums["/"+strings.TrimPrefix(path, "/")] = checksum
+			}
 		case "prerm":
 			fallthrough
 		case "preinst":
@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
@@ -173,8 +174,9 @@ exit 0
 	dpkg.VarDir = dbdir
 	dpkg.Install([]string{dest})
 
-	// Check the database
-	testutil.CheckFileContains(t, filepath.Join(dbdir, "status"), `Package: vim
+	// Check the database (Installed-Size counts the parent directories of testdir too)
+	installedSize := strings.Count(testdir, "/") + 2
+	testutil.CheckFileContains(t, filepath.Join(dbdir, "status"), fmt.Sprintf(`Package: vim
 Status: install ok installed
 Priority: optional
 Section: editors
@@ -204,12 +206,18 @@ Section: base
 Priority: optional
 Architecture: all
 Maintainer: Julien Sobczak
+Installed-Size: %d
 Description: Test
-`)
+`, installedSize))
 	testutil.CheckFileContains(t, filepath.Join(dbdir, "info/test.md5sums"), fmt.Sprintf(`a6101e71800b523cb9533b074387d693  %s/out/test
 `, testdir))
-	testutil.CheckFileContains(t, filepath.Join(dbdir, "info/test.list"), fmt.Sprintf(`%s/out/test
-`, testdir))
+	// Directories are tracked too
+	var expectedList string
+	for dir := testdir; dir != "/"; dir = filepath.Dir(dir) {
+		expectedList = dir + "\n" + expectedList
+	}
+	expectedList += fmt.Sprintf("%s/out\n%s/out/test\n", testdir, testdir)
+	testutil.CheckFileContains(t, filepath.Join(dbdir, "info/test.list"), expectedList)
 	testutil.CheckFileContains(t, filepath.Join(dbdir, "info/test.preinst"), `#!/bin/bash
 echo "preinst says hello";
 `)
@@ -0,0 +1,115 @@
+package dpkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// LockTimeout is the maximum duration to wait for a lock held by another process (0 = fail immediately).
+	LockTimeout time.Duration = 0
+
+	// FrontendLocked is true when a frontend (ex: apt) already holds the frontend lock.
+	FrontendLocked bool = os.Getenv("DPKG_FRONTEND_LOCKED") != ""
+)
+
+// lockRetryInterval is the delay between two attempts when waiting for a lock.
+const lockRetryInterval = 100 * time.Millisecond
+
+// LockError reports a lock held by another process.
+type LockError struct {
+	Path string
+	Pid  int
+}
+
+func (e *LockError) Error() string {
+	msg := fmt.Sprintf("Could not get lock %s. It is held by process %d", e.Path, e.Pid)
+	// Ex: /proc/1234/comm => apt
+	if comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", e.Pid)); err == nil {
+		msg += fmt.Sprintf(" (%s)", strings.TrimSpace(string(comm)))
+	}
+	return msg
+}
+
+// Lock is a set of fcntl locks compatible with the ones used by dpkg and apt.
+type Lock struct {
+	files []*os.File
+}
+
+// AcquireLock locks the files in order, waiting at most LockTimeout for each of them.
+func AcquireLock(paths ...string) (*Lock, error) {
+	lock := &Lock{}
+	for _, path := range paths {
+		f, err := lockFile(path)
+		if err != nil {
+			lock.Release()
+			return nil, err
+		}
+		lock.files = append(lock.files, f)
+	}
+	return lock, nil
+}
+
+// LockDatabase locks the dpkg database (including the frontend lock if not already held).
+func LockDatabase() (*Lock, error) {
+	var paths []string
+	if !FrontendLocked {
+		paths = append(paths, filepath.Join(VarDir, "lock-frontend"))
+	}
+	paths = append(paths, filepath.Join(VarDir, "lock"))
+	return AcquireLock(paths...)
+}
+
+// Release unlocks the files in reverse order.
+func (l *Lock) Release() {
+	for i := len(l.files) - 1; i >= 0; i-- {
+		// Closing the file releases the lock
+		l.files[i].Close()
+	}
+	l.files = nil
+}
+
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0640)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open lock file %s: %v", path, err)
+	}
+
+	deadline := time.Now().Add(LockTimeout)
+	waiting := false
+	for {
+		flock := syscall.Flock_t{
+			Type:   syscall.F_WRLCK,
+			Whence: 0, // SEEK_SET
+			Start:  0,
+			Len:    0, // Whole file
+		}
+		err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &flock)
+		if err == nil {
+			return f, nil
+		}
+		if err != syscall.EAGAIN && err != syscall.EACCES {
+			f.Close()
+			return nil, fmt.Errorf("Could not get lock %s: %v", path, err)
+		}
+
+		// Find the process holding the lock
+		lockErr := &LockError{Path: path}
+		if err := syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &flock); err == nil && flock.Type != syscall.F_UNLCK {
+			lockErr.Pid = int(flock.Pid)
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, lockErr
+		}
+		if !waiting {
+			fmt.Printf("Waiting for lock: %s...\n", lockErr)
+			waiting = true
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
@@ -0,0 +1,82 @@
+package dpkg_test
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+)
+
+func TestLock(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+	dpkg.VarDir = testdir
+
+	// fcntl locks are per process. Use a different process to hold the lock.
+	cmd := exec.Command(os.Args[0], "-test.run=TestLockHelperProcess")
+	cmd.Env = append(os.Environ(), "DPKG_TEST_LOCK_DIR="+testdir)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+	if line, err := bufio.NewReader(stdout).ReadString('\n'); err != nil || line != "locked\n" {
+		t.Fatalf("Helper process failed to lock the database: %q %v", line, err)
+	}
+
+	// Fail immediately
+	_, err = dpkg.LockDatabase()
+	var lockErr *dpkg.LockError
+	if !errors.As(err, &lockErr) {
+		t.Fatalf("Expected a lock error, got %v", err)
+	}
+	if lockErr.Pid != cmd.Process.Pid || lockErr.Path != filepath.Join(testdir, "lock-frontend") {
+		t.Errorf("Unexpected lock error: %v", lockErr)
+	}
+
+	// Wait for the lock to be released
+	dpkg.LockTimeout = 5 * time.Second
+	defer func() { dpkg.LockTimeout = 0 }()
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		stdin.Close()
+	}()
+	lock, err := dpkg.LockDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Release()
+}
+
+// TestLockHelperProcess holds the database lock until stdin is closed.
+func TestLockHelperProcess(t *testing.T) {
+	dir := os.Getenv("DPKG_TEST_LOCK_DIR")
+	if dir == "" {
+		t.Skip("Helper process for TestLock")
+	}
+	dpkg.VarDir = dir
+	lock, err := dpkg.LockDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout.WriteString("locked\n")
+	ioutil.ReadAll(os.Stdin)
+	lock.Release()
+}
@@ -0,0 +1,77 @@
+package dpkg
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+// Owner is the owner and group of a file inside a package.
+type Owner struct {
+	Uid   int
+	Gid   int
+	Uname string
+	Gname string
+}
+
+// RootOwner is the default owner of packaged files.
+var RootOwner = Owner{Uid: 0, Gid: 0, Uname: "root", Gname: "root"}
+
+var (
+	// RootOwnerGroup is true to package files as owned by root (like dpkg-deb --root-owner-group).
+	// Files keep their owner on the filesystem otherwise, except for reproducible builds (SOURCE_DATE_EPOCH).
+	RootOwnerGroup bool
+
+	// OwnerMapping overrides the owner of files inside the package, like chown under fakeroot.
+	// Ex: "/var/log/hello" => www-data:adm
+	OwnerMapping = make(map[string]Owner)
+)
+
+// ParseOwner parses a mapping like "/var/log/hello=www-data:adm" or "/srv/hello=33:4".
+func ParseOwner(value string) (string, Owner, error) {
+	var owner Owner
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return "", owner, fmt.Errorf("invalid owner mapping %q (expected path=user:group)", value)
+	}
+	path := "/" + strings.Trim(parts[0], "/")
+	ids := strings.SplitN(parts[1], ":", 2)
+	if len(ids) != 2 {
+		return "", owner, fmt.Errorf("invalid owner mapping %q (expected path=user:group)", value)
+	}
+
+	if uid, err := strconv.Atoi(ids[0]); err == nil {
+		owner.Uid = uid
+	} else {
+		owner.Uname = ids[0]
+		if u, err := user.Lookup(ids[0]); err == nil {
+			owner.Uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if gid, err := strconv.Atoi(ids[1]); err == nil {
+		owner.Gid = gid
+	} else {
+		owner.Gname = ids[1]
+		if g, err := user.LookupGroup(ids[1]); err == nil {
+			owner.Gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return path, owner, nil
+}
+
+// lookupOwner returns the ids to use on the current system for a file owner.
+// Names take precedence over ids as ids can differ between systems.
+func lookupOwner(uname string, gname string, uid int, gid int) (int, int) {
+	if uname != "" {
+		if u, err := user.Lookup(uname); err == nil {
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if gname != "" {
+		if g, err := user.LookupGroup(gname); err == nil {
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return uid, gid
+}
@@ -2,14 +2,16 @@ package dpkg
 
 import (
 	"archive/tar"
-	"bytes"
 	"crypto/md5"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"syscall"
 
 	"github.com/julien-sobczak/deb822"
 )
@@ -25,6 +27,10 @@ type PackageInfo struct {
 
 	Status      string // Current status (as also present in Paragraph under the field Status)
 	StatusDirty bool   // True to ask for sync
+
+	previous *PackageInfo // Version being upgraded, if any
+	pending  []string     // Files unpacked with the extension .dpkg-new, not yet renamed
+	created  []string     // Paths that did not exist before being unpacked (including parent directories)
 }
 
 func (p *PackageInfo) Name() string {
@@ -53,14 +59,82 @@ func (p *PackageInfo) SetStatus(new string) {
 	p.Paragraph.Values["Status"] = fmt.Sprintf("%s %s %s", parts[0], parts[1], new)
 }
 
-func (p *PackageInfo) Unpack(buf bytes.Buffer) error {
-	if err := p.runMaintainerScript("preinst"); err != nil {
+// ConfigVersion returns the last version successfully configured, if any.
+func (p *PackageInfo) ConfigVersion() string {
+	if p.Status == "installed" {
+		return p.Version()
+	}
+	return p.Paragraph.Value("Config-Version")
+}
+
+// setField adds or overrides a field in the status paragraph.
+func (p *PackageInfo) setField(name, value string) {
+	if _, ok := p.Paragraph.Values[name]; !ok {
+		p.Paragraph.Order = append(p.Paragraph.Order, name)
+	}
+	p.Paragraph.Values[name] = value
+	p.StatusDirty = true
+}
+
+// deleteField removes a field from the status paragraph.
+func (p *PackageInfo) deleteField(name string) {
+	if _, ok := p.Paragraph.Values[name]; !ok {
+		return
+	}
+	delete(p.Paragraph.Values, name)
+	for i, field := range p.Paragraph.Order {
+		if field == name {
+			p.Paragraph.Order = append(p.Paragraph.Order[:i], p.Paragraph.Order[i+1:]...)
+			break
+		}
+	}
+	p.StatusDirty = true
+}
+
+// Depends returns the names of the packages listed in Pre-Depends and Depends (including alternatives).
+func (p *PackageInfo) Depends() []string {
+	var names []string
+	for _, field := range []string{"Pre-Depends", "Depends"} {
+		value := strings.TrimSpace(p.Paragraph.Value(field))
+		if value == "" {
+			continue
+		}
+		for _, alternative := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '|' }) {
+			// Ex: "libc6 (>= 2.15)", "python3:any"
+			if name := relationNameRegex.FindString(strings.TrimSpace(alternative)); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+var relationNameRegex = regexp.MustCompile(`^[\w.+-]+`)
+
+// SetSelection overrides the desired action (install, hold, deinstall, purge).
+func (p *PackageInfo) SetSelection(want string) {
+	p.StatusDirty = true
+	parts := strings.Split(p.Paragraph.Values["Status"], " ")
+	p.Paragraph.Values["Status"] = fmt.Sprintf("%s %s %s", want, parts[1], parts[2])
+}
+
+// Unpack extracts the files read from the data tarball.
+// Regular files are written using the extension .dpkg-new and are only renamed
+// once the whole archive was unpacked so that an interrupted installation
+// never leaves half-written files in place.
+func (p *PackageInfo) Unpack(tr *tar.Reader) error {
+	preinstArgs := []string{"install"}
+	if p.previous != nil {
+		preinstArgs = []string{"upgrade", p.previous.Version()}
+	}
+	if err := p.runMaintainerScript("preinst", preinstArgs...); err != nil {
 		return err
 	}
 
 	fmt.Printf("Unpacking %s (%s) ...\n", p.Name(), p.Version())
+	p.SetStatus("half-installed")
+	p.Sync()
 
-	tr := tar.NewReader(&buf)
 	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
@@ -70,82 +144,392 @@ func (p *PackageInfo) Unpack(buf bytes.Buffer) error {
 			return err
 		}
 
-		var buf bytes.Buffer
-		if _, err := io.Copy(&buf, tr); err != nil {
+		if err := p.unpackEntry(hdr, tr); err != nil {
 			return err
 		}
+	}
+
+	// Replace the old files now that the archive was entirely read
+	dirs := make(map[string]bool)
+	for len(p.pending) > 0 {
+		path := filepath.Join(RootDir, p.pending[0])
+		if err := os.Rename(path+".dpkg-new", path); err != nil {
+			return fmt.Errorf("failed to install %s: %v", p.pending[0], err)
+		}
+		dirs[filepath.Dir(path)] = true
+		p.pending = p.pending[1:]
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
 
-		switch hdr.Typeflag {
-		case tar.TypeReg:
-			dest := hdr.Name
-			if strings.HasPrefix(dest, "./") {
-				// ./usr/bin/hello => /usr/bin/hello
-				dest = dest[1:]
+	if p.previous != nil {
+		// Remove files no longer present in the new version
+		for _, path := range removalOrder(p.previous.Files) {
+			if p.hasFile(path) || p.previous.isConffile(path) {
+				continue
 			}
-			if !strings.HasPrefix(dest, "/") {
-				// usr/bin/hello => /usr/bin/hello
-				dest = "/" + dest
+			if err := removePath(filepath.Join(RootDir, path)); err != nil {
+				return err
 			}
+		}
+		if err := p.previous.runMaintainerScript("postrm", "upgrade", p.Version()); err != nil {
+			return err
+		}
+	}
 
-			tmpdest := dest
-			if p.isConffile(tmpdest) {
-				// Extract using the extension .dpkg-new
-				tmpdest += ".dpkg-new"
-			}
+	p.SetStatus("unpacked")
+	p.Sync()
+
+	return nil
+}
 
-			if err := os.MkdirAll(filepath.Join(RootDir, filepath.Dir(tmpdest)), 0755); err != nil {
-				return fmt.Errorf("failed to unpack directory %s: %v", tmpdest, err)
+// unpackEntry recreates a single tar entry under RootDir, preserving its type and metadata.
+func (p *PackageInfo) unpackEntry(hdr *tar.Header, content io.Reader) error {
+	dest := entryPath(hdr.Name)
+	if dest == "/" {
+		// The root directory is listed as /. like dpkg does
+		p.Files = append(p.Files, "/.")
+		return nil
+	}
+
+	tmpdest := dest
+	deferred := hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeLink
+	if deferred {
+		// Extract using the extension .dpkg-new
+		// (conffiles are renamed when configuring the package)
+		tmpdest += ".dpkg-new"
+		if info, err := os.Lstat(filepath.Join(RootDir, dest)); err == nil && info.IsDir() {
+			return fmt.Errorf("failed to unpack file %s: a directory already exists", dest)
+		}
+	}
+	if hdr.Typeflag == tar.TypeLink {
+		// The target may also be waiting to be renamed
+		if target := entryPath(hdr.Linkname); p.isPending(target) || p.isConffile(target) {
+			link := *hdr
+			link.Linkname = target + ".dpkg-new"
+			hdr = &link
+		}
+	}
+
+	// Remember the paths created to be able to undo a failed installation
+	for path := dest; path != "/"; path = filepath.Dir(path) {
+		if _, err := os.Lstat(filepath.Join(RootDir, path)); !os.IsNotExist(err) {
+			break
+		}
+		p.created = append(p.created, path)
+	}
+
+	hash := md5.New()
+	err := extractEntry(RootDir, tmpdest, hdr, io.TeeReader(content, hash))
+	if err == errUnsupportedEntry {
+		fmt.Printf("dpkg: warning: ignoring unsupported file type for %s\n", dest)
+		return nil
+	}
+	if err != nil {
+		if deferred {
+			// Do not leave a partially written file
+			removePath(filepath.Join(RootDir, tmpdest))
+		}
+		return err
+	}
+	if hdr.Typeflag == tar.TypeReg {
+		p.MD5sums[dest] = fmt.Sprintf("%x", hash.Sum(nil))
+	}
+	if deferred && !p.isConffile(dest) {
+		p.pending = append(p.pending, dest)
+	}
+
+	p.Files = append(p.Files, dest)
+	return nil
+}
+
+// replacedFiles returns true if an unpacked entry may have replaced a file of the system.
+// Directories and files still using the extension .dpkg-new are not considered.
+func (p *PackageInfo) replacedFiles() bool {
+	for _, path := range p.Files {
+		if p.isPending(path) || p.isConffile(path) {
+			continue
+		}
+		if info, err := os.Lstat(filepath.Join(RootDir, path)); err == nil && info.IsDir() {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// isPending returns true if the file was unpacked but not yet renamed.
+func (p *PackageInfo) isPending(path string) bool {
+	for _, pending := range p.pending {
+		if pending == path {
+			return true
+		}
+	}
+	return false
+}
+
+// errUnsupportedEntry is returned for tar entries that cannot be recreated.
+var errUnsupportedEntry = errors.New("unsupported file type")
+
+// extractEntry recreates a tar entry at name under root, preserving its type and metadata.
+func extractEntry(root string, name string, hdr *tar.Header, content io.Reader) error {
+	path, err := securePath(root, name)
+	if err != nil {
+		return fmt.Errorf("failed to unpack %s: %v", name, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to unpack directory %s: %v", filepath.Dir(name), err)
+	}
+
+	mode := os.FileMode(hdr.Mode).Perm()
+	if hdr.Mode&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if hdr.Mode&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if hdr.Mode&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		// Directories can be shared between packages
+		info, err := os.Lstat(path)
+		if err == nil && info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(path); err == nil && target.IsDir() {
+				// Keep symlinks to directories (ex: /lib -> usr/lib)
+				return nil
+			}
+		}
+		if err != nil || !info.IsDir() {
+			if err := replaceable(path); err != nil {
+				return fmt.Errorf("failed to unpack directory %s: %v", name, err)
+			}
+			if err := os.Mkdir(path, mode); err != nil {
+				return fmt.Errorf("failed to unpack directory %s: %v", name, err)
 			}
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	case tar.TypeReg:
+		if err := replaceable(path); err != nil {
+			return fmt.Errorf("failed to unpack file %s: %v", name, err)
+		}
+		if err := copyFileSync(path, content, mode); err != nil {
+			return fmt.Errorf("failed to unpack file %s: %v", name, err)
+		}
+		// The umask may have restricted the permissions
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	case tar.TypeSymlink:
+		if err := replaceable(path); err != nil {
+			return fmt.Errorf("failed to unpack symlink %s: %v", name, err)
+		}
+		if err := os.Symlink(hdr.Linkname, path); err != nil {
+			return fmt.Errorf("failed to unpack symlink %s: %v", name, err)
+		}
+	case tar.TypeLink:
+		if err := replaceable(path); err != nil {
+			return fmt.Errorf("failed to unpack hard link %s: %v", name, err)
+		}
+		target, err := securePath(root, entryPath(hdr.Linkname))
+		if err != nil {
+			return fmt.Errorf("failed to unpack hard link %s to %s: %v", name, hdr.Linkname, err)
+		}
+		if err := os.Link(target, path); err != nil {
+			return fmt.Errorf("failed to unpack hard link %s: %v", name, err)
+		}
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if err := replaceable(path); err != nil {
+			return fmt.Errorf("failed to unpack device %s: %v", name, err)
+		}
+		deviceType := map[byte]uint32{
+			tar.TypeChar:  syscall.S_IFCHR,
+			tar.TypeBlock: syscall.S_IFBLK,
+			tar.TypeFifo:  syscall.S_IFIFO,
+		}[hdr.Typeflag]
+		dev := mkdev(hdr.Devmajor, hdr.Devminor)
+		if err := syscall.Mknod(path, deviceType|uint32(mode.Perm()), dev); err != nil {
+			return fmt.Errorf("failed to unpack device %s: %v", name, err)
+		}
+	default:
+		return errUnsupportedEntry
+	}
 
-			content := buf.Bytes()
-			if err := os.WriteFile(filepath.Join(RootDir, tmpdest), content, 0755); err != nil {
-				return fmt.Errorf("failed to unpack file %s: %v", tmpdest, err)
+	// Restore the owner (only possible as root)
+	if os.Geteuid() == 0 {
+		uid, gid := lookupOwner(hdr.Uname, hdr.Gname, hdr.Uid, hdr.Gid)
+		if err := os.Lchown(path, uid, gid); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeSymlink && mode&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			// chown clears the setuid/setgid bits
+			if err := os.Chmod(path, mode); err != nil {
+				return err
 			}
+		}
+	}
 
-			p.Files = append(p.Files, dest)
-			p.MD5sums[dest] = fmt.Sprintf("%x", md5.Sum(content))
+	// Restore the modification time
+	if hdr.Typeflag != tar.TypeSymlink && hdr.Typeflag != tar.TypeLink && !hdr.ModTime.IsZero() {
+		if err := os.Chtimes(path, hdr.ModTime, hdr.ModTime); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	p.SetStatus("unpacked")
-	p.Sync()
+// entryPath converts a name in data.tar to an absolute path.
+func entryPath(name string) string {
+	// ./usr/bin/hello => /usr/bin/hello
+	// usr/bin/hello => /usr/bin/hello
+	// ./usr/ => /usr
+	dest := name
+	if dest == "." || strings.HasPrefix(dest, "./") {
+		dest = dest[1:]
+	}
+	if !strings.HasPrefix(dest, "/") {
+		dest = "/" + dest
+	}
+	if dest != "/" {
+		dest = strings.TrimSuffix(dest, "/")
+	}
+	return dest
+}
 
-	return nil
+// securePath returns the location of name under root.
+// Names are always relative to root (/etc/shadow => <root>/etc/shadow)
+// but cannot contain .. components or traverse symlinks leading outside root.
+// The last component is not resolved as it is replaced when unpacking.
+func securePath(root string, name string) (string, error) {
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == ".." {
+			return "", fmt.Errorf("path contains a '..' component")
+		}
+	}
+	path := filepath.Join(root, name)
+
+	// Resolve the symlinks in the existing parent directories
+	realRoot, err := resolveExisting(root)
+	if err != nil {
+		return "", err
+	}
+	parent, err := resolveExisting(filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	if parent != realRoot && !strings.HasPrefix(parent, realRoot+string(filepath.Separator)) && realRoot != string(filepath.Separator) {
+		return "", fmt.Errorf("path escapes the root directory %s through a symlink", root)
+	}
+	return path, nil
+}
+
+// resolveExisting evaluates the symlinks in the longest existing prefix of path.
+// Missing directories are kept as is as they will be created by MkdirAll.
+func resolveExisting(path string) (string, error) {
+	var missing []string
+	for {
+		resolved, err := filepath.EvalSymlinks(path)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, missing...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if _, err := os.Lstat(path); err == nil {
+			return "", fmt.Errorf("dangling symlink %s", path)
+		}
+		missing = append([]string{filepath.Base(path)}, missing...)
+		path = filepath.Dir(path)
+	}
+}
+
+// replaceable removes an existing file (but not a directory) before unpacking a new one.
+func replaceable(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("a directory already exists")
+	}
+	return os.Remove(path)
+}
+
+// mkdev encodes a device number like the glibc makedev macro.
+func mkdev(major int64, minor int64) int {
+	return int((minor & 0xff) | ((major & 0xfff) << 8) | ((minor &^ 0xff) << 12) | ((major &^ 0xfff) << 32))
+}
+
+func (p *PackageInfo) hasFile(path string) bool {
+	for _, file := range p.Files {
+		if file == path {
+			return true
+		}
+	}
+	return false
 }
 
 func (p *PackageInfo) Configure() error {
 	fmt.Printf("Setting up %s (%s) ...\n", p.Name(), p.Version())
 
-	// Rename conffiles
-	for _, conffile := range p.Conffiles {
-		// Rename to remove extension .dpkg-new
-		tmpConffile := filepath.Join(RootDir, conffile+".dpkg-new")
-		if _, err := os.Stat(tmpConffile); os.IsNotExist(err) {
-			return fmt.Errorf("conffile %s is missing", tmpConffile)
+	if p.Status == "unpacked" {
+		if err := p.installConffiles(); err != nil {
+			return err
 		}
-		os.Rename(tmpConffile, filepath.Join(RootDir, conffile))
+		p.SetStatus("half-configured")
+		p.Sync()
 	}
-	p.SetStatus("half-configured")
-	p.Sync()
 
 	// Run maintainer script
-	if err := p.runMaintainerScript("postinst"); err != nil {
+	postinstArgs := []string{"configure"}
+	if configVersion := p.Paragraph.Value("Config-Version"); configVersion != "" {
+		postinstArgs = append(postinstArgs, configVersion)
+	}
+	if err := p.runMaintainerScript("postinst", postinstArgs...); err != nil {
 		return err
 	}
 	p.SetStatus("installed")
+	p.deleteField("Config-Version")
 	p.Sync()
 
 	return nil
 }
 
-func (p *PackageInfo) runMaintainerScript(name string) error {
-	_, ok := p.MaintainerScripts[name]
+func (p *PackageInfo) runMaintainerScript(name string, args ...string) error {
+	script, ok := p.MaintainerScripts[name]
 	if !ok {
 		// Nothing to run
 		return nil
 	}
 
-	out, err := exec.Command("/bin/sh", p.InfoPath(name)).Output()
+	path := p.InfoPath(name)
+	if content, err := os.ReadFile(path); err != nil || string(content) != script {
+		// The info file was overwritten by another version (ex: old postrm during an upgrade)
+		tmp, err := os.CreateTemp("", p.Name()+"."+name)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		if _, err := tmp.WriteString(script); err != nil {
+			tmp.Close()
+			return err
+		}
+		tmp.Close()
+		path = tmp.Name()
+	}
+
+	out, err := exec.Command("/bin/sh", append([]string{path}, args...)...).Output()
 	if err != nil {
 		return err
 	}
@@ -180,7 +564,16 @@ func (p *PackageInfo) InfoPath(filename string) string {
 	return filepath.Join(infoPath, p.PrefixName()+"."+filename)
 }
 
+// Sync writes the info files and records the new status in the journal.
 func (p *PackageInfo) Sync() error {
+	if err := p.syncInfo(); err != nil {
+		return err
+	}
+	return recordUpdate(p.Paragraph)
+}
+
+// syncInfo writes the files under the info directory.
+func (p *PackageInfo) syncInfo() error {
 	// Write <package>.list
 	if err := os.WriteFile(p.InfoPath("list"), []byte(FormatList(p.Files)), 0644); err != nil {
 		return err
@@ -197,7 +590,15 @@ func (p *PackageInfo) Sync() error {
 	}
 
 	// Write <package>.{preinst,prerm,postinst,postrm}
-	for name, content := range p.MaintainerScripts {
+	for _, name := range []string{"preinst", "postinst", "prerm", "postrm"} {
+		content, ok := p.MaintainerScripts[name]
+		if !ok {
+			// Remove scripts from a previous version
+			if err := removePath(p.InfoPath(name)); err != nil {
+				return err
+			}
+			continue
+		}
 		err := os.WriteFile(p.InfoPath(name), []byte(content), 0755)
 		if err != nil {
 			return err
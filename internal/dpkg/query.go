@@ -0,0 +1,335 @@
+package dpkg
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/julien-sobczak/deb822"
+)
+
+// List prints the packages matching the patterns (like dpkg --list).
+func List(patterns []string) {
+	runQuery(func(db *Directory) (string, error) { return db.List(patterns) })
+}
+
+// ShowStatus prints the status paragraph of packages (like dpkg --status).
+func ShowStatus(pkgNames []string) {
+	runQuery(func(db *Directory) (string, error) { return db.ShowStatus(pkgNames) })
+}
+
+// ListFiles prints the files owned by packages (like dpkg --listfiles).
+func ListFiles(pkgNames []string) {
+	runQuery(func(db *Directory) (string, error) { return db.ListFiles(pkgNames) })
+}
+
+// Search prints the packages owning the files matching the patterns (like dpkg --search).
+func Search(patterns []string) {
+	runQuery(func(db *Directory) (string, error) { return db.Search(patterns) })
+}
+
+// runQuery prints the output of a query on the database.
+func runQuery(query func(db *Directory) (string, error)) {
+	// Read the database
+	db, err := Load()
+	if err != nil {
+		fmt.Printf("Unable to read the database: %v", err)
+		os.Exit(1)
+	}
+	output, err := query(db)
+	fmt.Print(output)
+	if err != nil {
+		fmt.Printf("dpkg-query: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// listHeader explains the state columns of dpkg --list.
+const listHeader = `Desired=Unknown/Install/Remove/Purge/Hold
+| Status=Not/Inst/Conf-files/Unpacked/halF-conf/Half-inst/trig-aWait/Trig-pend
+|/ Err?=(none)/Reinst-required (Status,Err: uppercase=bad)
+`
+
+// List returns a table of the packages whose name matches a pattern (ex: "lib*").
+func (d *Directory) List(patterns []string) (string, error) {
+	pkgs, unmatched := d.selectPackages(patterns)
+
+	// Ex: ii  hello          2.10-2       all          Say Hello
+	rows := [][]string{{"Name", "Version", "Architecture", "Description"}}
+	for _, pkg := range pkgs {
+		description := strings.SplitN(pkg.Paragraph.Value("Description"), "\n", 2)[0]
+		rows = append(rows, []string{pkg.Name(), pkg.Version(), pkg.Paragraph.Value("Architecture"), description})
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, value := range row {
+			if len(value) > widths[i] {
+				widths[i] = len(value)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	if len(pkgs) > 0 {
+		sb.WriteString(listHeader)
+		sb.WriteString(formatRow("||/", rows[0], widths))
+		rules := make([]string, len(widths))
+		for i, width := range widths {
+			rules[i] = strings.Repeat("=", width)
+		}
+		sb.WriteString("+++-" + strings.Join(rules, "-") + "\n")
+		for i, pkg := range pkgs {
+			sb.WriteString(formatRow(pkg.stateAbbrev(), rows[i+1], widths))
+		}
+	}
+
+	if len(unmatched) > 0 {
+		return sb.String(), fmt.Errorf("no packages found matching %s", strings.Join(unmatched, " "))
+	}
+	return sb.String(), nil
+}
+
+// selectPackages returns the packages whose name matches a pattern sorted by name,
+// and the patterns matching no package.
+// Packages matched by several patterns are returned once.
+// Packages not installed are only selected when explicitly requested.
+func (d *Directory) selectPackages(patterns []string) ([]*PackageInfo, []string) {
+	var pkgs []*PackageInfo
+	var unmatched []string
+	selected := make(map[string]bool)
+	if len(patterns) == 0 {
+		for _, pkg := range d.Packages {
+			if pkg.Status != "not-installed" {
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	for _, pattern := range patterns {
+		found := false
+		for _, pkg := range d.Packages {
+			if matched, _ := path.Match(pattern, pkg.Name()); matched {
+				if !selected[pkg.Name()] {
+					selected[pkg.Name()] = true
+					pkgs = append(pkgs, pkg)
+				}
+				found = true
+			}
+		}
+		if !found {
+			unmatched = append(unmatched, pattern)
+		}
+	}
+	sort.SliceStable(pkgs, func(i, j int) bool { return pkgs[i].Name() < pkgs[j].Name() })
+	return pkgs, unmatched
+}
+
+// formatRow formats a line of dpkg --list.
+func formatRow(state string, values []string, widths []int) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%-3s", state))
+	for i, value := range values {
+		if i == len(values)-1 {
+			sb.WriteString(" " + value)
+		} else {
+			sb.WriteString(fmt.Sprintf(" %-*s", widths[i], value))
+		}
+	}
+	return strings.TrimRight(sb.String(), " ") + "\n"
+}
+
+// stateAbbrev returns the desired action, the status and the error flag (ex: "ii ", "rc ").
+func (p *PackageInfo) stateAbbrev() string {
+	// Ex: install ok installed
+	parts := strings.Split(p.Paragraph.Value("Status"), " ")
+	if len(parts) != 3 {
+		return "???"
+	}
+	want := map[string]string{
+		"unknown":   "u",
+		"install":   "i",
+		"hold":      "h",
+		"deinstall": "r",
+		"purge":     "p",
+	}[parts[0]]
+	status := map[string]string{
+		"not-installed":    "n",
+		"config-files":     "c",
+		"half-installed":   "H",
+		"unpacked":         "U",
+		"half-configured":  "F",
+		"triggers-awaited": "W",
+		"triggers-pending": "t",
+		"installed":        "i",
+	}[parts[2]]
+	flag := " "
+	if parts[1] == "reinstreq" {
+		flag = "R"
+	}
+	if want == "" {
+		want = "?"
+	}
+	if status == "" {
+		status = "?"
+	}
+	return want + status + flag
+}
+
+// ShowStatus returns the status paragraph of the packages.
+func (d *Directory) ShowStatus(pkgNames []string) (string, error) {
+	var doc deb822.Document
+	var missing []string
+	for _, pkgName := range pkgNames {
+		pkg := d.GetPackage(pkgName)
+		if pkg == nil {
+			missing = append(missing, pkgName)
+			continue
+		}
+		doc.Paragraphs = append(doc.Paragraphs, pkg.Paragraph)
+	}
+	var output string
+	if len(doc.Paragraphs) > 0 {
+		output = formatStatus(doc)
+	}
+	if len(missing) > 0 {
+		return output, fmt.Errorf("package '%s' is not installed and no information is available", missing[0])
+	}
+	return output, nil
+}
+
+// ListFiles returns the files owned by the packages, a blank line between packages.
+func (d *Directory) ListFiles(pkgNames []string) (string, error) {
+	var lists []string
+	var missing []string
+	for _, pkgName := range pkgNames {
+		pkg := d.GetPackage(pkgName)
+		if pkg == nil || pkg.Status == "not-installed" {
+			missing = append(missing, pkgName)
+			continue
+		}
+		lists = append(lists, FormatList(pkg.Files))
+	}
+	output := strings.Join(lists, "\n")
+	if len(missing) > 0 {
+		return output, fmt.Errorf("package '%s' is not installed", missing[0])
+	}
+	return output, nil
+}
+
+// Search returns the files matching the patterns with the packages owning them.
+// Ex: "hello: /usr/bin/hello"
+// Patterns are absolute paths, globs (ex: "*/bin/*") or parts of a path (ex: "bin/hello").
+func (d *Directory) Search(patterns []string) (string, error) {
+	index := d.FileIndex()
+	var sb strings.Builder
+	var unmatched []string
+	for _, pattern := range patterns {
+		paths := index.Match(pattern)
+		if len(paths) == 0 {
+			unmatched = append(unmatched, pattern)
+			continue
+		}
+		for _, path := range paths {
+			sb.WriteString(fmt.Sprintf("%s: %s\n", strings.Join(index[path], ", "), path))
+		}
+	}
+	if len(unmatched) > 0 {
+		return sb.String(), fmt.Errorf("no path found matching pattern %s", strings.Join(unmatched, " "))
+	}
+	return sb.String(), nil
+}
+
+// FileIndex maps the installed files to the names of the packages owning them.
+// Ex: "/usr/bin/hello" => ["hello"]
+type FileIndex map[string][]string
+
+// FileIndex returns the index of the files owned by the packages.
+func (d *Directory) FileIndex() FileIndex {
+	index := make(FileIndex)
+	for _, pkg := range d.Packages {
+		for _, file := range pkg.Files {
+			if file == "/." {
+				continue
+			}
+			index[file] = append(index[file], pkg.Name())
+		}
+	}
+	return index
+}
+
+// sharedWith returns true if a package other than pkgName owns the path.
+func (i FileIndex) sharedWith(path string, pkgName string) bool {
+	for _, name := range i[path] {
+		if name != pkgName {
+			return true
+		}
+	}
+	return false
+}
+
+// Match returns the sorted paths matching the pattern.
+// Absolute paths without wildcards are looked up directly.
+// Other patterns are matched against every path, with implicit wildcards
+// around patterns not starting with / or a wildcard, like dpkg --search does.
+func (i FileIndex) Match(pattern string) []string {
+	wildcard := strings.ContainsAny(pattern, "*?[\\")
+	if !wildcard && strings.HasPrefix(pattern, "/") {
+		if _, ok := i[pattern]; ok {
+			return []string{pattern}
+		}
+		return nil
+	}
+	if !strings.HasPrefix(pattern, "/") && !strings.HasPrefix(pattern, "*") {
+		pattern = "*" + pattern + "*"
+	}
+
+	re, err := globRegexp(pattern)
+	if err != nil {
+		return nil
+	}
+	var paths []string
+	for file := range i {
+		if re.MatchString(file) {
+			paths = append(paths, file)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// globRegexp converts a shell pattern to a regular expression.
+// Unlike path.Match, wildcards also match / (like fnmatch without FNM_PATHNAME).
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid pattern %s", pattern)
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
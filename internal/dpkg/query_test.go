@@ -0,0 +1,131 @@
+package dpkg_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestQuery(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello/DEBIAN/control": []byte(`Package: hello
+Version: 2.10-2
+Architecture: amd64
+Maintainer: Julien Sobczak
+Description: Say Hello
+ The GNU hello program produces a familiar, friendly greeting.
+`),
+		"hello/usr/bin/hello":              []byte(`#!/bin/sh`),
+		"hello/usr/share/doc/hello/README": []byte(`Hello`),
+		"hello-l10n/DEBIAN/control": []byte(`Package: hello-l10n
+Version: 1.0
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Translations for hello
+`),
+		"hello-l10n/DEBIAN/conffiles":       []byte("/etc/hello/l10n.conf\n"),
+		"hello-l10n/etc/hello/l10n.conf":    []byte("lang=fr\n"),
+		"hello-l10n/usr/share/doc/hello/fr": []byte(`Bonjour`),
+		"dpkg/status":                       []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	if err := os.MkdirAll(filepath.Join(testdir, "dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"hello", "hello-l10n"} {
+		dpkg.Build(filepath.Join(testdir, name), filepath.Join(testdir, name+".deb"))
+	}
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = filepath.Join(testdir, "root")
+	defer func() { dpkg.RootDir = "/" }()
+	dpkg.Install([]string{filepath.Join(testdir, "hello.deb"), filepath.Join(testdir, "hello-l10n.deb")})
+	dpkg.Remove([]string{"hello-l10n"}, false)
+
+	db, err := dpkg.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// --list
+	expected := `Desired=Unknown/Install/Remove/Purge/Hold
+| Status=Not/Inst/Conf-files/Unpacked/halF-conf/Half-inst/trig-aWait/Trig-pend
+|/ Err?=(none)/Reinst-required (Status,Err: uppercase=bad)
+||/ Name       Version Architecture Description
++++-==========-=======-============-======================
+ii  hello      2.10-2  amd64        Say Hello
+rc  hello-l10n 1.0     all          Translations for hello
+`
+	if actual := testutil.CaptureStdout(t, func() { dpkg.List(nil) }); actual != expected {
+		t.Errorf("Unexpected list:\n%s\nExpected:\n%s", actual, expected)
+	}
+	if actual, err := db.List([]string{"*-l10n"}); err != nil || !strings.Contains(actual, "\nrc  hello-l10n 1.0     all          Translations for hello\n") {
+		t.Errorf("Unexpected list for a pattern (%v):\n%s", err, actual)
+	}
+	if actual, err := db.List([]string{"hello*", "*-l10n"}); err != nil || strings.Count(actual, "hello-l10n") != 1 {
+		t.Errorf("Unexpected list for overlapping patterns (%v):\n%s", err, actual)
+	}
+	if _, err := db.List([]string{"bye*"}); err == nil || err.Error() != "no packages found matching bye*" {
+		t.Errorf("Unexpected error for an unknown pattern: %v", err)
+	}
+
+	// --status
+	if actual, err := db.ShowStatus([]string{"hello"}); err != nil || actual != `Package: hello
+Status: install ok installed
+Version: 2.10-2
+Architecture: amd64
+Maintainer: Julien Sobczak
+Installed-Size: 7
+Description: Say Hello
+ The GNU hello program produces a familiar, friendly greeting.
+` {
+		t.Errorf("Unexpected status (%v):\n%s", err, actual)
+	}
+	if _, err := db.ShowStatus([]string{"bye"}); err == nil || err.Error() != "package 'bye' is not installed and no information is available" {
+		t.Errorf("Unexpected error for an unknown package: %v", err)
+	}
+
+	// --listfiles
+	if actual, err := db.ListFiles([]string{"hello", "hello-l10n"}); err != nil || actual != `/usr
+/usr/bin
+/usr/bin/hello
+/usr/share
+/usr/share/doc
+/usr/share/doc/hello
+/usr/share/doc/hello/README
+
+/etc/hello/l10n.conf
+` {
+		t.Errorf("Unexpected files (%v):\n%s", err, actual)
+	}
+
+	// --search
+	for _, tt := range []struct {
+		patterns []string
+		expected string
+	}{
+		{[]string{"/usr/bin/hello"}, "hello: /usr/bin/hello\n"},
+		{[]string{"/etc/hello/l10n.conf"}, "hello-l10n: /etc/hello/l10n.conf\n"},
+		{[]string{"doc/hello"}, "hello: /usr/share/doc/hello\nhello: /usr/share/doc/hello/README\n"},
+		{[]string{"/usr/*/hello"}, "hello: /usr/bin/hello\nhello: /usr/share/doc/hello\n"},
+		{[]string{"*READ?E", "/usr/bin"}, "hello: /usr/share/doc/hello/README\nhello: /usr/bin\n"},
+	} {
+		if actual, err := db.Search(tt.patterns); err != nil || actual != tt.expected {
+			t.Errorf("Unexpected search results for %v (%v):\n%s", tt.patterns, err, actual)
+		}
+	}
+	if _, err := db.Search([]string{"/usr/bin/bye"}); err == nil || err.Error() != "no path found matching pattern /usr/bin/bye" {
+		t.Errorf("Unexpected error for an unknown path: %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package dpkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func Remove(pkgNames []string, purge bool) {
+	// Lock the database
+	lock, err := LockDatabase()
+	if err != nil {
+		fmt.Printf("dpkg: error: %s\n", err)
+		os.Exit(1)
+	}
+	defer lock.Release()
+
+	// Read the database
+	db, err := Load()
+	if err != nil {
+		fmt.Printf("Unable to read the database: %v", err)
+		os.Exit(1)
+	}
+	fmt.Printf("(Reading database ... %d files and directories currently installed.)\n", db.InstalledFiles())
+
+	// Dependants removed in the same run do not prevent the removal
+	removing := make(map[string]bool)
+	for _, pkgName := range pkgNames {
+		removing[pkgName] = true
+	}
+
+	for _, pkgName := range pkgNames {
+		err := processRemoval(db, pkgName, purge, removing)
+		if err != nil {
+			delete(removing, pkgName)
+			fmt.Printf("dpkg: error processing package %s (--remove):\n %s\n", pkgName, err)
+			fmt.Printf("Errors were encountered while processing:\n\t%s\n", pkgName)
+		}
+	}
+}
+
+func processRemoval(db *Directory, pkgName string, purge bool, removing map[string]bool) error {
+	pkg := db.GetPackage(pkgName)
+	if pkg == nil || pkg.Status == "not-installed" {
+		fmt.Printf("dpkg: warning: ignoring request to remove %s which isn't installed\n", pkgName)
+		return nil
+	}
+	if pkg.Status == "config-files" && !purge {
+		fmt.Printf("dpkg: warning: ignoring request to remove %s, only the config\n files of which are on the system; use --purge to remove them too\n", pkgName)
+		return nil
+	}
+
+	if pkg.Status != "config-files" {
+		// Do not break the installed packages depending on it
+		if problems := db.brokenDependants(pkg, removing); len(problems) > 0 {
+			fmt.Printf("dpkg: dependency problems prevent removal of %s:\n", pkgName)
+			for _, problem := range problems {
+				fmt.Printf(" %s\n", problem)
+			}
+			fmt.Println()
+			return fmt.Errorf("dependency problems - not removing")
+		}
+
+		if err := pkg.Remove(db.FileIndex()); err != nil {
+			db.Sync()
+			return err
+		}
+	}
+	if purge {
+		if err := pkg.Purge(); err != nil {
+			db.Sync()
+			return err
+		}
+	}
+
+	if pkg.Status == "not-installed" {
+		// Forget about the package
+		if err := pkg.RemoveInfo(); err != nil {
+			return err
+		}
+		db.RemovePackage(pkg)
+	}
+
+	return db.Sync()
+}
+
+// Remove deletes the files of an installed package except its conffiles
+// and the files still owned by other packages.
+func (p *PackageInfo) Remove(index FileIndex) error {
+	fmt.Printf("Removing %s (%s) ...\n", p.Name(), p.Version())
+	p.SetSelection("deinstall")
+
+	if err := p.runMaintainerScript("prerm", "remove"); err != nil {
+		// Error unwind: the package stays installed
+		if err := p.runMaintainerScript("postinst", "abort-remove"); err != nil {
+			fmt.Printf("dpkg: error while cleaning up:\n %s\n", err)
+		} else {
+			p.SetSelection("install")
+		}
+		return err
+	}
+	p.SetStatus("half-installed")
+	p.Sync()
+
+	var remaining []string
+	for _, path := range removalOrder(p.Files) {
+		if p.isConffile(path) {
+			remaining = append(remaining, path)
+			continue
+		}
+		if index.sharedWith(path, p.Name()) {
+			delete(p.MD5sums, path)
+			continue
+		}
+		if err := removePath(filepath.Join(RootDir, path)); err != nil {
+			return err
+		}
+		delete(p.MD5sums, path)
+	}
+	p.Files = remaining
+
+	if err := p.runMaintainerScript("postrm", "remove"); err != nil {
+		return err
+	}
+
+	if len(p.Conffiles) > 0 {
+		p.SetStatus("config-files")
+	} else {
+		p.SetStatus("not-installed")
+	}
+	p.Sync()
+
+	return nil
+}
+
+// Purge deletes the conffiles of a removed package.
+func (p *PackageInfo) Purge() error {
+	fmt.Printf("Purging configuration files for %s (%s) ...\n", p.Name(), p.Version())
+	p.SetSelection("purge")
+
+	for _, conffile := range p.Conffiles {
+		for _, suffix := range []string{"", ".dpkg-new", ".dpkg-old", ".dpkg-dist"} {
+			if err := removePath(filepath.Join(RootDir, conffile+suffix)); err != nil {
+				return err
+			}
+		}
+	}
+	p.Files = nil
+	p.MD5sums = make(map[string]string)
+
+	if err := p.runMaintainerScript("postrm", "purge"); err != nil {
+		return err
+	}
+
+	p.SetStatus("not-installed")
+	return nil
+}
+
+// RemoveInfo deletes the files under the info directory.
+func (p *PackageInfo) RemoveInfo() error {
+	for _, name := range []string{"list", "md5sums", "conffiles", "preinst", "postinst", "prerm", "postrm"} {
+		if err := removePath(p.InfoPath(name)); err != nil {
+			return err
+		}
+	}
+	p.StatusDirty = false
+	return nil
+}
+
+// brokenDependants returns the problems caused by removing the package,
+// ignoring the dependants removed at the same time.
+// Ex: "world depends on hello (>= 2.0)."
+func (d *Directory) brokenDependants(pkg *PackageInfo, removing map[string]bool) []string {
+	before := func(candidate *PackageInfo) bool {
+		return candidate != pkg && removing[candidate.Name()]
+	}
+	after := func(candidate *PackageInfo) bool {
+		return candidate == pkg || removing[candidate.Name()]
+	}
+
+	var problems []string
+	for _, dependant := range d.Packages {
+		if after(dependant) || dependant.Status == "not-installed" || dependant.Status == "config-files" {
+			continue
+		}
+		for _, field := range []string{"Pre-Depends", "Depends"} {
+			value := strings.TrimSpace(dependant.Paragraph.Value(field))
+			if value == "" {
+				continue
+			}
+			for _, entry := range strings.Split(value, ",") {
+				if d.satisfiedExcept(entry, before) && !d.satisfiedExcept(entry, after) {
+					problems = append(problems, fmt.Sprintf("%s %s on %s.", dependant.Name(), strings.ToLower(field), strings.TrimSpace(entry)))
+				}
+			}
+		}
+	}
+	return problems
+}
+
+// satisfiedExcept returns true if an alternative of the relation (ex: "debconf | debconf-2.0")
+// is satisfied by the installed packages that are not excluded.
+func (d *Directory) satisfiedExcept(entry string, excluded func(*PackageInfo) bool) bool {
+	available := func(candidate *PackageInfo) bool {
+		return candidate.Status != "not-installed" && candidate.Status != "config-files" && !excluded(candidate)
+	}
+	for _, alternative := range strings.Split(entry, "|") {
+		res := relationRegex.FindStringSubmatch(strings.TrimSpace(alternative))
+		if res == nil {
+			continue
+		}
+		name := res[relationRegex.SubexpIndex("name")]
+		relation := res[relationRegex.SubexpIndex("relation")]
+		version := res[relationRegex.SubexpIndex("version")]
+
+		if target := d.GetPackage(name); target != nil && available(target) {
+			if version == "" || relationSatisfiedBy(target.Version(), relation, version) {
+				return true
+			}
+		}
+		if version != "" {
+			continue
+		}
+		for _, provider := range d.Packages {
+			if !available(provider) {
+				continue
+			}
+			for _, provided := range strings.Split(provider.Paragraph.Value("Provides"), ",") {
+				if relationNameRegex.FindString(strings.TrimSpace(provided)) == name {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// removalOrder sorts paths to remove the deepest ones first.
+// The root directory (/.) is never removed.
+func removalOrder(paths []string) []string {
+	var sorted []string
+	for _, path := range paths {
+		if path != "/." {
+			sorted = append(sorted, path)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+	return sorted
+}
+
+// removePath deletes a file, or a directory if empty. Missing files are ignored.
+func removePath(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		// Directories can be shared with other packages
+		os.Remove(path)
+		return nil
+	}
+	return os.Remove(path)
+}
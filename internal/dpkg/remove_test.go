@@ -0,0 +1,172 @@
+package dpkg_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestRemove(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"2.1-1/DEBIAN/control": []byte(`Package: hello
+Version: 2.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"2.1-1/DEBIAN/conffiles": []byte(`/etc/hello/settings.conf
+`),
+		"2.1-1/DEBIAN/postrm": []byte(`#!/bin/sh
+echo "postrm $1" >> ` + testdir + `/postrm.log
+`),
+		"2.1-1/usr/bin/hello": []byte(`#!/bin/sh
+echo "Hello";
+`),
+		"2.1-1/etc/hello/settings.conf": []byte(`lang=en
+`),
+
+		"dpkg/status": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	if err := os.MkdirAll(filepath.Join(testdir, "dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Install the package
+	dest := filepath.Join(testdir, "hello.deb")
+	dpkg.Build(filepath.Join(testdir, "2.1-1"), dest)
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = testdir
+	defer func() { dpkg.RootDir = "/" }()
+	dpkg.Install([]string{dest})
+	testutil.CheckFileExists(t, filepath.Join(testdir, "usr/bin/hello"))
+
+	// Remove keeps conffiles
+	dpkg.Remove([]string{"hello"}, false)
+	if _, err := os.Stat(filepath.Join(testdir, "usr/bin/hello")); !os.IsNotExist(err) {
+		t.Errorf("File /usr/bin/hello must be removed")
+	}
+	testutil.CheckFileExists(t, filepath.Join(testdir, "etc/hello/settings.conf"))
+	testutil.CheckFileContains(t, filepath.Join(testdir, "dpkg/status"), `Package: hello
+Status: deinstall ok config-files
+Version: 2.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Installed-Size: 6
+Description: Say Hello
+Conffiles:
+ /etc/hello/settings.conf 5e073bfeb5393e30c817648253c53467
+`)
+
+	// Purge removes everything
+	dpkg.Remove([]string{"hello"}, true)
+	if _, err := os.Stat(filepath.Join(testdir, "etc/hello/settings.conf")); !os.IsNotExist(err) {
+		t.Errorf("Conffile /etc/hello/settings.conf must be removed")
+	}
+	if _, err := os.Stat(filepath.Join(testdir, "dpkg/info/hello.list")); !os.IsNotExist(err) {
+		t.Errorf("File hello.list must be removed")
+	}
+	testutil.CheckFileContains(t, filepath.Join(testdir, "dpkg/status"), ``)
+	testutil.CheckFileContains(t, filepath.Join(testdir, "postrm.log"), `postrm remove
+postrm purge
+`)
+}
+
+func TestRemoveDependencies(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"dpkg/status": []byte(`Package: hello
+Status: install ok installed
+Version: 2.1-1
+Architecture: all
+
+Package: world
+Status: install ok installed
+Version: 1.0-1
+Architecture: all
+Depends: hello (>= 2.0)
+
+Package: hello-doc
+Status: install ok installed
+Version: 2.1-1
+Architecture: all
+Depends: hello
+
+Package: old
+Status: deinstall ok config-files
+Version: 1.0-1
+Architecture: all
+Conffiles:
+ /etc/old.conf 5e073bfeb5393e30c817648253c53467
+`),
+		"dpkg/info/hello.list":     []byte("/usr\n/usr/bin\n/usr/bin/hello\n/usr/share/hello/common\n"),
+		"dpkg/info/world.list":     []byte("/usr\n/usr/bin\n/usr/bin/world\n/usr/share/hello/common\n"),
+		"dpkg/info/hello-doc.list": []byte("/usr\n/usr/share/doc/hello\n"),
+		"dpkg/info/old.list":       []byte("/etc/old.conf\n"),
+		"dpkg/info/old.conffiles":  []byte("/etc/old.conf\n"),
+		"usr/bin/hello":            []byte(`hello`),
+		"usr/bin/world":            []byte(`world`),
+		"usr/share/hello/common":   []byte(`common`),
+		"usr/share/doc/hello":      []byte(`doc`),
+		"etc/old.conf":             []byte(`lang=en`),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = testdir
+	defer func() { dpkg.RootDir = "/" }()
+
+	// Installed dependants prevent the removal
+	output := testutil.CaptureStdout(t, func() { dpkg.Remove([]string{"hello"}, false) })
+	if !strings.Contains(output, "dpkg: dependency problems prevent removal of hello:\n world depends on hello (>= 2.0).\n hello-doc depends on hello.\n") ||
+		!strings.Contains(output, "dependency problems - not removing") {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+	testutil.CheckFileExists(t, filepath.Join(testdir, "usr/bin/hello"))
+
+	// Files owned by another package are kept
+	dpkg.Remove([]string{"world"}, false)
+	if _, err := os.Stat(filepath.Join(testdir, "usr/bin/world")); !os.IsNotExist(err) {
+		t.Errorf("File /usr/bin/world must be removed")
+	}
+	testutil.CheckFileExists(t, filepath.Join(testdir, "usr/share/hello/common"))
+
+	// Packages in config-files state are only removed with --purge
+	output = testutil.CaptureStdout(t, func() { dpkg.Remove([]string{"old"}, false) })
+	if !strings.Contains(output, "dpkg: warning: ignoring request to remove old, only the config\n files of which are on the system; use --purge to remove them too") {
+		t.Errorf("Unexpected output:\n%s", output)
+	}
+	testutil.CheckFileExists(t, filepath.Join(testdir, "etc/old.conf"))
+
+	// Dependants removed at the same time do not prevent the removal
+	dpkg.Remove([]string{"hello", "hello-doc"}, false)
+	for _, path := range []string{"usr/bin/hello", "usr/share/hello/common", "usr/share/doc/hello"} {
+		if _, err := os.Stat(filepath.Join(testdir, path)); !os.IsNotExist(err) {
+			t.Errorf("File /%s must be removed", path)
+		}
+	}
+	testutil.CheckFileContains(t, filepath.Join(testdir, "dpkg/status"), `Package: old
+Status: deinstall ok config-files
+Version: 1.0-1
+Architecture: all
+Conffiles:
+ /etc/old.conf 5e073bfeb5393e30c817648253c53467
+`)
+}
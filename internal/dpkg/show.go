@@ -0,0 +1,209 @@
+package dpkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DefaultShowFormat is the format used by dpkg-query --show when none is given.
+const DefaultShowFormat = "${binary:Package}\t${Version}\n"
+
+// Show prints the packages matching the patterns using a format (like dpkg-query --show).
+// Ex: "${Package}\t${Version}\t${Installed-Size}\n"
+func Show(patterns []string, format string) {
+	runQuery(func(db *Directory) (string, error) { return db.Show(patterns, format) })
+}
+
+// ShowJSON prints the status of the packages matching the patterns as a JSON array.
+func ShowJSON(patterns []string) {
+	runQuery(func(db *Directory) (string, error) { return db.ShowJSON(patterns) })
+}
+
+// Show formats the packages whose name matches a pattern (all installed packages when none is given).
+func (d *Directory) Show(patterns []string, format string) (string, error) {
+	showFormat, err := ParseShowFormat(format)
+	if err != nil {
+		return "", err
+	}
+	pkgs, unmatched := d.selectPackages(patterns)
+	var sb strings.Builder
+	for _, pkg := range pkgs {
+		sb.WriteString(showFormat.Format(pkg))
+	}
+	if len(unmatched) > 0 {
+		return sb.String(), fmt.Errorf("no packages found matching %s", strings.Join(unmatched, " "))
+	}
+	return sb.String(), nil
+}
+
+// ShowJSON returns the status paragraphs of the packages whose name matches a pattern
+// as a JSON array. Fields are kept in the order of the status file.
+func (d *Directory) ShowJSON(patterns []string) (string, error) {
+	pkgs, unmatched := d.selectPackages(patterns)
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for i, pkg := range pkgs {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString("{")
+		for j, field := range pkg.Paragraph.Order {
+			if j > 0 {
+				buf.WriteString(",")
+			}
+			key, _ := json.Marshal(field)
+			value, _ := json.Marshal(pkg.Paragraph.Value(field))
+			buf.Write(key)
+			buf.WriteString(":")
+			buf.Write(value)
+		}
+		buf.WriteString("}")
+	}
+	buf.WriteString("]")
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, buf.Bytes(), "", "  "); err != nil {
+		return "", err
+	}
+	out.WriteString("\n")
+	if len(unmatched) > 0 {
+		return out.String(), fmt.Errorf("no packages found matching %s", strings.Join(unmatched, " "))
+	}
+	return out.String(), nil
+}
+
+// ShowFormat is a parsed format of dpkg-query --showformat.
+type ShowFormat []showToken
+
+// showToken is either a literal string or a field reference.
+type showToken struct {
+	literal string
+	field   string // Ex: Version, db:Status-Abbrev
+	width   int    // Right-aligned when positive, left-aligned when negative
+}
+
+// ParseShowFormat parses a format like "${Package;-20} ${Version}\n".
+// Fields are referenced using ${name} with an optional width (${name;width})
+// and the escape sequences \n, \r, \t and \\ are supported.
+func ParseShowFormat(format string) (ShowFormat, error) {
+	var tokens ShowFormat
+	var literal strings.Builder
+	flush := func() {
+		if literal.Len() > 0 {
+			tokens = append(tokens, showToken{literal: literal.String()})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		switch {
+		case c == '\\' && i+1 < len(format):
+			i++
+			switch format[i] {
+			case 'n':
+				literal.WriteByte('\n')
+			case 'r':
+				literal.WriteByte('\r')
+			case 't':
+				literal.WriteByte('\t')
+			default:
+				literal.WriteByte(format[i])
+			}
+		case c == '$' && i+1 < len(format) && format[i+1] == '{':
+			end := strings.IndexByte(format[i+2:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("missing closing brace in format %q", format)
+			}
+			token := showToken{field: format[i+2 : i+2+end]}
+			if name, width, ok := strings.Cut(token.field, ";"); ok {
+				n, err := strconv.Atoi(strings.TrimSpace(width))
+				if err != nil {
+					return nil, fmt.Errorf("invalid field width '%s' in format %q", width, format)
+				}
+				token.field = name
+				token.width = n
+			}
+			flush()
+			tokens = append(tokens, token)
+			i += end + 2
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens, nil
+}
+
+// Format formats the package. Unknown fields are replaced by an empty string.
+func (f ShowFormat) Format(p *PackageInfo) string {
+	var sb strings.Builder
+	for _, token := range f {
+		if token.field == "" {
+			sb.WriteString(token.literal)
+			continue
+		}
+		sb.WriteString(fmt.Sprintf("%*s", token.width, p.FieldValue(token.field)))
+	}
+	return sb.String()
+}
+
+// FieldValue returns the value of a field of the status paragraph (case-insensitive)
+// or of a virtual field supported by dpkg-query:
+//   - binary:Package: the package name with the architecture for Multi-Arch: same packages
+//   - binary:Summary: the first line of the description
+//   - db:Status-Abbrev: the abbreviated status (ex: "ii ")
+//   - db:Status-Want, db:Status-Status, db:Status-Eflag: the parts of the field Status
+//   - db-fsys:Files: the installed files, one per line
+//   - source:Package, source:Version: the source package name and version
+func (p *PackageInfo) FieldValue(name string) string {
+	status := strings.Split(p.Paragraph.Value("Status"), " ")
+	for len(status) < 3 {
+		status = append(status, "")
+	}
+	source, sourceVersion, _ := strings.Cut(p.Paragraph.Value("Source"), " ")
+
+	switch strings.ToLower(name) {
+	case "binary:package":
+		if p.Paragraph.Value("Multi-Arch") == "same" {
+			return p.Name() + ":" + p.Paragraph.Value("Architecture")
+		}
+		return p.Name()
+	case "binary:summary":
+		return strings.SplitN(p.Paragraph.Value("Description"), "\n", 2)[0]
+	case "db:status-abbrev":
+		return p.stateAbbrev()
+	case "db:status-want":
+		return status[0]
+	case "db:status-eflag":
+		return status[1]
+	case "db:status-status":
+		return status[2]
+	case "db-fsys:files":
+		var sb strings.Builder
+		for _, file := range p.Files {
+			sb.WriteString(" " + file + "\n")
+		}
+		return sb.String()
+	case "source:package":
+		if source == "" {
+			return p.Name()
+		}
+		return source
+	case "source:version":
+		// Ex: Source: hello (2.10-1)
+		if sourceVersion != "" {
+			return strings.Trim(sourceVersion, "()")
+		}
+		return p.Version()
+	}
+
+	for _, field := range p.Paragraph.Order {
+		if strings.EqualFold(field, name) {
+			return p.Paragraph.Value(field)
+		}
+	}
+	return ""
+}
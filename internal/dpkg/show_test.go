@@ -0,0 +1,109 @@
+package dpkg_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestShow(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"hello/DEBIAN/control": []byte(`Package: hello
+Source: hello-src (2.10-1)
+Version: 2.10-2
+Architecture: amd64
+Maintainer: Julien Sobczak
+Description: Say Hello
+ The GNU hello program produces a familiar, "friendly" greeting.
+`),
+		"hello/usr/bin/hello": []byte(`#!/bin/sh`),
+		"libhello/DEBIAN/control": []byte(`Package: libhello
+Version: 1.0
+Architecture: amd64
+Multi-Arch: same
+Maintainer: Julien Sobczak
+Description: Hello library
+`),
+		"libhello/usr/lib/libhello.so": []byte(`ELF`),
+		"dpkg/status":                  []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	if err := os.MkdirAll(filepath.Join(testdir, "dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"hello", "libhello"} {
+		dpkg.Build(filepath.Join(testdir, name), filepath.Join(testdir, name+".deb"))
+	}
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = filepath.Join(testdir, "root")
+	defer func() { dpkg.RootDir = "/" }()
+	dpkg.Install([]string{filepath.Join(testdir, "hello.deb"), filepath.Join(testdir, "libhello.deb")})
+
+	db, err := dpkg.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		patterns []string
+		format   string
+		expected string
+	}{
+		{nil, dpkg.DefaultShowFormat, "hello\t2.10-2\nlibhello:amd64\t1.0\n"},
+		{nil, `${Package}\t${Version}\t${Installed-Size}\n`, "hello\t2.10-2\t3\nlibhello\t1.0\t3\n"},
+		{[]string{"hello"}, `[${Package;-8}][${Version;8}] ${db:Status-Abbrev}|\n`, "[hello   ][  2.10-2] ii |\n"},
+		{[]string{"hello"}, `${db:Status-Want} ${db:Status-Eflag} ${db:Status-Status}\n`, "install ok installed\n"},
+		{[]string{"hello"}, `${binary:Summary}: ${source:Package} ${source:Version}\n`, "Say Hello: hello-src 2.10-1\n"},
+		{[]string{"lib*"}, `${source:Package} ${source:Version}\n`, "libhello 1.0\n"},
+		{[]string{"hello"}, `${version} ${Unknown}|\\\$\n`, "2.10-2 |\\$\n"},
+		{[]string{"hello"}, `${db-fsys:Files}`, " /usr\n /usr/bin\n /usr/bin/hello\n"},
+	} {
+		actual, err := db.Show(tt.patterns, tt.format)
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tt.format, err)
+			continue
+		}
+		if actual != tt.expected {
+			t.Errorf("Unexpected output for %q:\n%q\nExpected:\n%q", tt.format, actual, tt.expected)
+		}
+	}
+
+	// Invalid formats and unknown packages are reported
+	for _, format := range []string{`${Package`, `${Package;abc}`} {
+		if _, err := db.Show(nil, format); err == nil {
+			t.Errorf("Expected an error for the format %q", format)
+		}
+	}
+	if _, err := db.Show([]string{"bye"}, dpkg.DefaultShowFormat); err == nil || err.Error() != "no packages found matching bye" {
+		t.Errorf("Unexpected error for an unknown package: %v", err)
+	}
+
+	// JSON
+	expected := `[
+  {
+    "Package": "hello",
+    "Status": "install ok installed",
+    "Source": "hello-src (2.10-1)",
+    "Version": "2.10-2",
+    "Architecture": "amd64",
+    "Maintainer": "Julien Sobczak",
+    "Installed-Size": "3",
+    "Description": "Say Hello\nThe GNU hello program produces a familiar, \"friendly\" greeting."
+  }
+]
+`
+	if actual := testutil.CaptureStdout(t, func() { dpkg.ShowJSON([]string{"hello"}) }); actual != expected {
+		t.Errorf("Unexpected JSON:\n%s\nExpected:\n%s", actual, expected)
+	}
+}
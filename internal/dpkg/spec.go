@@ -0,0 +1,354 @@
+package dpkg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/julien-sobczak/deb822"
+	"gopkg.in/yaml.v3"
+)
+
+// Spec describes a package declaratively (like nfpm) instead of a DEBIAN/ directory.
+type Spec struct {
+	Name         string `yaml:"name" toml:"name"`
+	Version      string `yaml:"version" toml:"version"`
+	Architecture string `yaml:"arch" toml:"arch"`
+	Maintainer   string `yaml:"maintainer" toml:"maintainer"`
+	Section      string `yaml:"section" toml:"section"`
+	Priority     string `yaml:"priority" toml:"priority"`
+	Homepage     string `yaml:"homepage" toml:"homepage"`
+	Description  string `yaml:"description" toml:"description"` // Synopsis on the first line
+
+	PreDepends []string `yaml:"pre-depends" toml:"pre-depends"`
+	Depends    []string `yaml:"depends" toml:"depends"`
+	Recommends []string `yaml:"recommends" toml:"recommends"`
+	Suggests   []string `yaml:"suggests" toml:"suggests"`
+	Breaks     []string `yaml:"breaks" toml:"breaks"`
+	Conflicts  []string `yaml:"conflicts" toml:"conflicts"`
+	Provides   []string `yaml:"provides" toml:"provides"`
+	Replaces   []string `yaml:"replaces" toml:"replaces"`
+
+	// Additional fields copied as is in the control file (ex: Multi-Arch)
+	Fields map[string]string `yaml:"fields" toml:"fields"`
+
+	Contents []SpecContent `yaml:"contents" toml:"contents"`
+	Scripts  SpecScripts   `yaml:"scripts" toml:"scripts"`
+
+	dir string // Directory of the spec file to resolve relative paths
+}
+
+// SpecContent maps a file or a directory tree to its destination inside the package.
+type SpecContent struct {
+	Src   string `yaml:"src" toml:"src"`
+	Dst   string `yaml:"dst" toml:"dst"`
+	Type  string `yaml:"type" toml:"type"` // "" (file or tree), "config" (conffile), "dir" or "symlink"
+	Mode  string `yaml:"mode" toml:"mode"` // Octal permissions like "0755" (default to the source mode)
+	Owner string `yaml:"owner" toml:"owner"`
+	Group string `yaml:"group" toml:"group"`
+}
+
+// SpecScripts references the maintainer scripts.
+type SpecScripts struct {
+	Preinst  string `yaml:"preinst" toml:"preinst"`
+	Postinst string `yaml:"postinst" toml:"postinst"`
+	Prerm    string `yaml:"prerm" toml:"prerm"`
+	Postrm   string `yaml:"postrm" toml:"postrm"`
+}
+
+// LoadSpec reads a YAML (.yaml, .yml) or TOML (.toml) spec file.
+func LoadSpec(path string) (*Spec, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec := &Spec{dir: filepath.Dir(path)}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(content))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(spec); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("parsing file '%s': %v", path, err)
+		}
+	case ".toml":
+		metadata, err := toml.Decode(string(content), spec)
+		if err != nil {
+			return nil, fmt.Errorf("parsing file '%s': %v", path, err)
+		}
+		if undecoded := metadata.Undecoded(); len(undecoded) > 0 {
+			return nil, fmt.Errorf("parsing file '%s': unknown field %q", path, undecoded[0].String())
+		}
+	default:
+		return nil, fmt.Errorf("unsupported spec file '%s' (expected .yaml, .yml or .toml)", path)
+	}
+
+	for i, content := range spec.Contents {
+		if content.Dst == "" {
+			return nil, fmt.Errorf("parsing file '%s': missing dst in contents[%d]", path, i)
+		}
+		if !filepath.IsLocal(strings.Trim(content.Dst, "/")) {
+			return nil, fmt.Errorf("parsing file '%s': unsafe dst %q in contents[%d]", path, content.Dst, i)
+		}
+		if content.Src == "" && content.Type != "dir" {
+			return nil, fmt.Errorf("parsing file '%s': missing src in contents[%d]", path, i)
+		}
+		if content.Mode != "" {
+			if _, err := strconv.ParseUint(content.Mode, 8, 32); err != nil {
+				return nil, fmt.Errorf("parsing file '%s': invalid mode %q in contents[%d]", path, content.Mode, i)
+			}
+		}
+		switch content.Type {
+		case "", "config", "dir", "symlink":
+		default:
+			return nil, fmt.Errorf("parsing file '%s': unknown type %q in contents[%d]", path, content.Type, i)
+		}
+	}
+	return spec, nil
+}
+
+// Control returns the control file described by the spec.
+func (s *Spec) Control() deb822.Paragraph {
+	control := deb822.Paragraph{Values: make(map[string]string)}
+	add := func(field string, value string) {
+		if value == "" {
+			return
+		}
+		control.Order = append(control.Order, field)
+		control.Values[field] = value
+	}
+	add("Package", s.Name)
+	add("Version", s.Version)
+	add("Section", s.Section)
+	add("Priority", s.Priority)
+	add("Architecture", s.Architecture)
+	add("Maintainer", s.Maintainer)
+	add("Pre-Depends", strings.Join(s.PreDepends, ", "))
+	add("Depends", strings.Join(s.Depends, ", "))
+	add("Recommends", strings.Join(s.Recommends, ", "))
+	add("Suggests", strings.Join(s.Suggests, ", "))
+	add("Breaks", strings.Join(s.Breaks, ", "))
+	add("Conflicts", strings.Join(s.Conflicts, ", "))
+	add("Provides", strings.Join(s.Provides, ", "))
+	add("Replaces", strings.Join(s.Replaces, ", "))
+	add("Homepage", s.Homepage)
+	var extra []string
+	for field := range s.Fields {
+		extra = append(extra, field)
+	}
+	sort.Strings(extra)
+	for _, field := range extra {
+		add(field, s.Fields[field])
+	}
+	add("Description", strings.TrimSpace(s.Description))
+	return control
+}
+
+// BuildSpec creates a debian archive from a spec file.
+// The files are staged in a temporary directory using the layout expected by Build.
+func BuildSpec(specPath string, dest string) {
+	spec, err := LoadSpec(specPath)
+	if err != nil {
+		fmt.Printf("dpkg-deb: error: %s\n", err)
+		os.Exit(1)
+	}
+	if err := ValidateControl(spec.Control()); err != nil {
+		fmt.Printf("dpkg-deb: error: parsing file '%s' package '%s':\n%s\n", specPath, spec.Name, err)
+		os.Exit(1)
+	}
+
+	directory, err := os.MkdirTemp("", "dpkg-spec.*")
+	if err != nil {
+		fmt.Printf("dpkg-deb: %s\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(directory)
+
+	owners, err := spec.stage(directory)
+	if err != nil {
+		os.RemoveAll(directory)
+		fmt.Printf("dpkg-deb: error: %s\n", err)
+		os.Exit(1)
+	}
+
+	// Like nfpm, files are owned by root unless the spec or the command line says otherwise
+	rootOwnerGroup := RootOwnerGroup
+	RootOwnerGroup = true
+	defer func() { RootOwnerGroup = rootOwnerGroup }()
+
+	// Apply the owners declared in the spec without losing the ones passed on the command line
+	previous := make(map[string]Owner)
+	for path, owner := range owners {
+		if current, ok := OwnerMapping[path]; ok {
+			previous[path] = current
+		}
+		OwnerMapping[path] = owner
+	}
+	defer func() {
+		for path := range owners {
+			delete(OwnerMapping, path)
+		}
+		for path, owner := range previous {
+			OwnerMapping[path] = owner
+		}
+	}()
+
+	Build(directory, dest)
+}
+
+// stage lays out the package in directory and returns the owners of the staged files.
+func (s *Spec) stage(directory string) (map[string]Owner, error) {
+	owners := make(map[string]Owner)
+	var conffiles []string
+
+	for _, content := range s.Contents {
+		dst := "/" + strings.Trim(content.Dst, "/")
+		target := filepath.Join(directory, dst)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, err
+		}
+
+		var staged []string // Destination paths
+		switch content.Type {
+		case "dir":
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return nil, err
+			}
+			staged = append(staged, dst)
+		case "symlink":
+			if err := os.Symlink(content.Src, target); err != nil {
+				return nil, err
+			}
+			staged = append(staged, dst)
+		default:
+			src := content.Src
+			if !filepath.IsAbs(src) {
+				src = filepath.Join(s.dir, src)
+			}
+			err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				name := filepath.Join(dst, strings.TrimPrefix(path, src))
+				if err := copyEntry(path, filepath.Join(directory, name), info); err != nil {
+					return err
+				}
+				staged = append(staged, name)
+				if content.Type == "config" && info.Mode().IsRegular() {
+					conffiles = append(conffiles, name)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, name := range staged {
+			if content.Mode != "" {
+				// Like nfpm, the mode of a tree applies to its regular files only
+				info, err := os.Lstat(filepath.Join(directory, name))
+				if err != nil {
+					return nil, err
+				}
+				if info.Mode().IsRegular() || (info.IsDir() && content.Type == "dir") {
+					mode, _ := strconv.ParseUint(content.Mode, 8, 32)
+					if err := os.Chmod(filepath.Join(directory, name), os.FileMode(mode)); err != nil {
+						return nil, err
+					}
+				}
+			}
+			if content.Owner != "" || content.Group != "" {
+				owner, group := content.Owner, content.Group
+				if owner == "" {
+					owner = RootOwner.Uname
+				}
+				if group == "" {
+					group = RootOwner.Gname
+				}
+				path, o, err := ParseOwner(fmt.Sprintf("%s=%s:%s", name, owner, group))
+				if err != nil {
+					return nil, err
+				}
+				owners[path] = o
+			}
+		}
+	}
+
+	// Generate the DEBIAN/ directory
+	controlDir := filepath.Join(directory, "DEBIAN")
+	if err := os.MkdirAll(controlDir, 0755); err != nil {
+		return nil, err
+	}
+	formatter := deb822.NewFormatter()
+	formatter.SetFoldedFields("Description")
+	control := formatter.Format(deb822.Document{Paragraphs: []deb822.Paragraph{s.Control()}})
+	if err := os.WriteFile(filepath.Join(controlDir, "control"), []byte(control), 0644); err != nil {
+		return nil, err
+	}
+	if len(conffiles) > 0 {
+		if err := os.WriteFile(filepath.Join(controlDir, "conffiles"), []byte(FormatConffiles(conffiles)), 0644); err != nil {
+			return nil, err
+		}
+	}
+	scripts := map[string]string{
+		"preinst":  s.Scripts.Preinst,
+		"postinst": s.Scripts.Postinst,
+		"prerm":    s.Scripts.Prerm,
+		"postrm":   s.Scripts.Postrm,
+	}
+	for name, src := range scripts {
+		if src == "" {
+			continue
+		}
+		if !filepath.IsAbs(src) {
+			src = filepath.Join(s.dir, src)
+		}
+		content, err := os.ReadFile(src)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(controlDir, name), content, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return owners, nil
+}
+
+// copyEntry copies a file, a directory or a symlink preserving its mode and modification time.
+func copyEntry(src string, dst string, info os.FileInfo) error {
+	switch {
+	case info.IsDir():
+		if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+	case info.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(link, dst)
+	case info.Mode().IsRegular():
+		content, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, content, info.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported file type for %s", src)
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
@@ -0,0 +1,232 @@
+package dpkg_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestBuildSpec(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"src/hello.yaml": []byte(`name: hello
+version: 2.10-2
+arch: all
+maintainer: Julien Sobczak
+section: devel
+depends:
+  - libc6 (>= 2.14)
+  - debconf | debconf-2.0
+fields:
+  Multi-Arch: foreign
+description: |
+  Say Hello
+  The GNU hello program produces a familiar, friendly greeting.
+
+  It is an example package.
+contents:
+  - src: build/hello
+    dst: /usr/bin/hello
+    mode: "0755"
+  - src: build/doc
+    dst: /usr/share/doc/hello
+    mode: "0600"
+  - src: build/hello.conf
+    dst: /etc/hello/hello.conf
+    type: config
+  - dst: /var/log/hello
+    type: dir
+    mode: "0750"
+    owner: daemon
+    group: adm
+  - src: hello
+    dst: /usr/bin/hi
+    type: symlink
+scripts:
+  postinst: scripts/postinst
+`),
+		"src/hello.toml": []byte(`name = "hello"
+version = "2.10-2"
+arch = "all"
+maintainer = "Julien Sobczak"
+section = "devel"
+depends = ["libc6 (>= 2.14)", "debconf | debconf-2.0"]
+description = """
+Say Hello
+The GNU hello program produces a familiar, friendly greeting.
+
+It is an example package.
+"""
+
+[fields]
+Multi-Arch = "foreign"
+
+[[contents]]
+src = "build/hello"
+dst = "/usr/bin/hello"
+mode = "0755"
+
+[[contents]]
+src = "build/doc"
+dst = "/usr/share/doc/hello"
+mode = "0600"
+
+[[contents]]
+src = "build/hello.conf"
+dst = "/etc/hello/hello.conf"
+type = "config"
+
+[[contents]]
+dst = "/var/log/hello"
+type = "dir"
+mode = "0750"
+owner = "daemon"
+group = "adm"
+
+[[contents]]
+src = "hello"
+dst = "/usr/bin/hi"
+type = "symlink"
+
+[scripts]
+postinst = "scripts/postinst"
+`),
+		"src/build/hello":                 []byte(`#!/bin/sh`),
+		"src/build/doc/copyright":         []byte(`GPL-3+`),
+		"src/build/doc/README":            []byte(`Hello`),
+		"src/build/doc/examples/hello.sh": []byte(`hello`),
+		"src/build/hello.conf":            []byte(`lang=en`),
+		"src/scripts/postinst":            []byte("#!/bin/sh\necho \"$1\" > " + testdir + "/postinst.log\n"),
+		"src/invalid.yaml":                []byte("name: hello\nversion: 1.0\nlicense: MIT\n"),
+		"src/invalid-control.toml":        []byte("name = \"Hello_World\"\n"),
+		"src/invalid-dst.yaml":            []byte("name: hello\ncontents:\n  - src: build/hello\n    dst: ../../etc/x\n"),
+		// The dependencies are already installed
+		"dpkg/status": []byte(`Package: libc6
+Status: install ok installed
+Version: 2.31-13
+Architecture: amd64
+
+Package: cdebconf
+Status: install ok installed
+Version: 0.260
+Architecture: amd64
+Provides: debconf-2.0
+`),
+		"dpkg/info/libc6.list":    []byte(``),
+		"dpkg/info/cdebconf.list": []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	if err := os.MkdirAll(filepath.Join(testdir, "dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// YAML and TOML specs are equivalent
+	yamlSpec, err := dpkg.LoadSpec(filepath.Join(testdir, "src/hello.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tomlSpec, err := dpkg.LoadSpec(filepath.Join(testdir, "src/hello.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(yamlSpec, tomlSpec) {
+		t.Errorf("Specs differ:\n%+v\n%+v", yamlSpec, tomlSpec)
+	}
+
+	// Unknown fields are rejected
+	if _, err := dpkg.LoadSpec(filepath.Join(testdir, "src/invalid.yaml")); err == nil || !strings.Contains(err.Error(), "license") {
+		t.Errorf("Unexpected error for an unknown field: %v", err)
+	}
+	// Destinations cannot escape the package
+	if _, err := dpkg.LoadSpec(filepath.Join(testdir, "src/invalid-dst.yaml")); err == nil || !strings.Contains(err.Error(), "unsafe dst") {
+		t.Errorf("Unexpected error for an unsafe destination: %v", err)
+	}
+	invalid, err := dpkg.LoadSpec(filepath.Join(testdir, "src/invalid-control.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dpkg.ValidateControl(invalid.Control()); err == nil {
+		t.Errorf("Expected the control file to be invalid")
+	}
+
+	// Build and install the package
+	archive := filepath.Join(testdir, "hello.deb")
+	dpkg.BuildSpec(filepath.Join(testdir, "src/hello.toml"), archive)
+	if names := controlNames(t, archive); strings.Join(names, " ") != "conffiles control postinst md5sums" {
+		t.Errorf("Unexpected control files: %v", names)
+	}
+	for _, hdr := range dataHeaders(t, archive) {
+		if hdr.Name == "var/log/hello/" && (hdr.Uname != "daemon" || hdr.Gname != "adm") {
+			t.Errorf("Unexpected owner for %s: %s:%s", hdr.Name, hdr.Uname, hdr.Gname)
+		}
+		// Other files are owned by root by default
+		if hdr.Name != "var/log/hello/" && (hdr.Uname != "root" || hdr.Gname != "root" || hdr.Uid != 0 || hdr.Gid != 0) {
+			t.Errorf("Unexpected owner for %s: %s:%s (%d:%d)", hdr.Name, hdr.Uname, hdr.Gname, hdr.Uid, hdr.Gid)
+		}
+	}
+	if len(dpkg.OwnerMapping) != 0 || dpkg.RootOwnerGroup {
+		t.Errorf("Owners declared in the spec must not leak: %v", dpkg.OwnerMapping)
+	}
+
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = filepath.Join(testdir, "root")
+	defer func() { dpkg.RootDir = "/" }()
+	dpkg.Install([]string{archive})
+	root := dpkg.RootDir
+
+	checkMode(t, filepath.Join(root, "usr/bin/hello"), 0755)
+	checkMode(t, filepath.Join(root, "var/log/hello"), os.ModeDir|0750)
+	// The mode of a tree applies to its regular files only
+	checkMode(t, filepath.Join(root, "usr/share/doc/hello"), os.ModeDir|0755)
+	checkMode(t, filepath.Join(root, "usr/share/doc/hello/examples"), os.ModeDir|0755)
+	checkMode(t, filepath.Join(root, "usr/share/doc/hello/copyright"), 0600)
+	checkMode(t, filepath.Join(root, "usr/share/doc/hello/examples/hello.sh"), 0600)
+	testutil.CheckFileContains(t, filepath.Join(root, "usr/share/doc/hello/copyright"), `GPL-3+`)
+	testutil.CheckFileContains(t, filepath.Join(root, "etc/hello/hello.conf"), `lang=en`)
+	if target, err := os.Readlink(filepath.Join(root, "usr/bin/hi")); err != nil || target != "hello" {
+		t.Errorf("Unexpected symlink target %q (%v)", target, err)
+	}
+	testutil.CheckFileContains(t, filepath.Join(testdir, "dpkg/info/hello.conffiles"), `/etc/hello/hello.conf
+`)
+	testutil.CheckFileContains(t, filepath.Join(testdir, "postinst.log"), `configure
+`)
+	testutil.CheckFileContains(t, filepath.Join(testdir, "dpkg/status"), `Package: libc6
+Status: install ok installed
+Version: 2.31-13
+Architecture: amd64
+
+Package: cdebconf
+Status: install ok installed
+Version: 0.260
+Architecture: amd64
+Provides: debconf-2.0
+
+Package: hello
+Status: install ok installed
+Version: 2.10-2
+Section: devel
+Architecture: all
+Maintainer: Julien Sobczak
+Installed-Size: 17
+Depends: libc6 (>= 2.14), debconf | debconf-2.0
+Multi-Arch: foreign
+Description: Say Hello
+ The GNU hello program produces a familiar, friendly greeting.
+ .
+ It is an example package.
+Conffiles:
+ /etc/hello/hello.conf 9ed2e74b5d2cc188faba67ac5010be64
+`)
+}
@@ -0,0 +1,374 @@
+package dpkg_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestMaliciousArchives(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	outside := filepath.Join(testdir, "outside")
+
+	for _, tt := range []struct {
+		name    string
+		entries []entry
+		safe    bool // The archive is legit
+	}{
+		{
+			name: "parent directory",
+			entries: []entry{
+				{name: "../../outside/evil", content: "pwned"},
+			},
+		},
+		{
+			name: "parent directory inside a path",
+			entries: []entry{
+				{name: "./usr/", typeflag: tar.TypeDir},
+				{name: "./usr/../../outside/evil", content: "pwned"},
+			},
+		},
+		{
+			name: "absolute path with parent directory",
+			entries: []entry{
+				{name: "/../outside/evil", content: "pwned"},
+			},
+		},
+		{
+			name: "absolute symlink",
+			entries: []entry{
+				{name: "./usr/", typeflag: tar.TypeDir},
+				{name: "./usr/link", typeflag: tar.TypeSymlink, linkname: outside},
+				{name: "./usr/link/evil", content: "pwned"},
+			},
+		},
+		{
+			name: "relative symlink",
+			entries: []entry{
+				{name: "./usr/", typeflag: tar.TypeDir},
+				{name: "./usr/link", typeflag: tar.TypeSymlink, linkname: "../../../outside"},
+				{name: "./usr/link/evil", content: "pwned"},
+			},
+		},
+		{
+			name: "symlink to a directory entry",
+			entries: []entry{
+				{name: "./var", typeflag: tar.TypeSymlink, linkname: outside},
+				{name: "./var/", typeflag: tar.TypeDir},
+				{name: "./var/evil", content: "pwned"},
+			},
+		},
+		{
+			name: "chained symlinks",
+			entries: []entry{
+				{name: "./a", typeflag: tar.TypeSymlink, linkname: "b"},
+				{name: "./b", typeflag: tar.TypeSymlink, linkname: "../../outside"},
+				{name: "./a/evil", content: "pwned"},
+			},
+		},
+		{
+			name: "hard link outside",
+			entries: []entry{
+				{name: "./secret", typeflag: tar.TypeLink, linkname: "../outside/secret"},
+			},
+		},
+		{
+			name: "hard link through a symlink",
+			entries: []entry{
+				{name: "./link", typeflag: tar.TypeSymlink, linkname: outside},
+				{name: "./secret", typeflag: tar.TypeLink, linkname: "./link/secret"},
+			},
+		},
+		{
+			name: "symlinks inside the root",
+			entries: []entry{
+				{name: "./usr/", typeflag: tar.TypeDir},
+				{name: "./usr/lib/", typeflag: tar.TypeDir},
+				{name: "./lib", typeflag: tar.TypeSymlink, linkname: "usr/lib"},
+				{name: "./lib/libhello.so", content: "library"},
+				{name: "./usr/bin/", typeflag: tar.TypeDir},
+				{name: "./usr/bin/hello", typeflag: tar.TypeSymlink, linkname: "/etc/alternatives/hello"},
+			},
+			safe: true,
+		},
+	} {
+		dir := filepath.Join(testdir, strings.ReplaceAll(tt.name, " ", "-"))
+		root := filepath.Join(dir, "root")
+		testutil.PopulateTestDir(t, dir, map[string][]byte{
+			"dpkg/status": []byte(``),
+		})
+		for _, path := range []string{"dpkg/info", "root"} {
+			if err := os.MkdirAll(filepath.Join(dir, path), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		testutil.PopulateTestDir(t, testdir, map[string][]byte{
+			"outside/secret": []byte(`secret`),
+		})
+		archive := filepath.Join(dir, "evil.deb")
+		writeDebian(t, archive, evilControl, tarball(t, tt.entries))
+
+		dpkg.VarDir = filepath.Join(dir, "dpkg")
+		dpkg.RootDir = root
+		dpkg.Install([]string{archive})
+		dpkg.RootDir = "/"
+
+		// Nothing must be written outside the root directory
+		if _, err := os.Stat(filepath.Join(outside, "evil")); !os.IsNotExist(err) {
+			t.Errorf("%s: file written outside the root directory", tt.name)
+		}
+		os.RemoveAll(filepath.Join(outside, "evil"))
+		testutil.CheckFileContains(t, filepath.Join(outside, "secret"), `secret`)
+		if info, err := os.Stat(filepath.Join(outside, "secret")); err == nil {
+			if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Nlink != 1 {
+				t.Errorf("%s: hard link created to a file outside the root directory", tt.name)
+			}
+		}
+
+		status, err := os.ReadFile(filepath.Join(dir, "dpkg/status"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		installed := strings.Contains(string(status), "Status: install ok installed")
+		if tt.safe && !installed {
+			t.Errorf("%s: legit archive not installed:\n%s", tt.name, status)
+		}
+		if !tt.safe && installed {
+			t.Errorf("%s: malicious archive installed", tt.name)
+		}
+	}
+
+	// Symlinks inside the root directory are followed
+	testutil.CheckFileContains(t, filepath.Join(testdir, "symlinks-inside-the-root/root/usr/lib/libhello.so"), `library`)
+}
+
+func TestInterruptedUnpack(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testutil.PopulateTestDir(t, testdir, map[string][]byte{
+		"dpkg/status": []byte(``),
+	})
+	if err := os.MkdirAll(filepath.Join(testdir, "dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	control := func(version string) string {
+		return "Package: hello\nVersion: " + version + "\nArchitecture: all\nMaintainer: Julien Sobczak\nDescription: Say Hello\n"
+	}
+	entries := func(content string) []entry {
+		return []entry{
+			{name: "./usr/", typeflag: tar.TypeDir},
+			{name: "./usr/bin/", typeflag: tar.TypeDir},
+			{name: "./usr/bin/hello", content: content},
+			{name: "./usr/bin/hi", typeflag: tar.TypeLink, linkname: "./usr/bin/hello"},
+			{name: "./usr/share/", typeflag: tar.TypeDir},
+			{name: "./usr/share/hello.txt", content: strings.Repeat(content, 1000)},
+		}
+	}
+	writeDebian(t, filepath.Join(testdir, "1.0.deb"), control("1.0"), tarball(t, entries("v1")))
+	writeDebian(t, filepath.Join(testdir, "2.0.deb"), control("2.0"), tarball(t, entries("v2")))
+	// The data stream stops in the middle of the last file
+	data := tarball(t, entries("v2"))
+	writeDebian(t, filepath.Join(testdir, "2.0-interrupted.deb"), control("2.0"), data[:len(data)-2048])
+
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = filepath.Join(testdir, "root")
+	defer func() { dpkg.RootDir = "/" }()
+	root := dpkg.RootDir
+
+	dpkg.Install([]string{filepath.Join(testdir, "1.0.deb")})
+	testutil.CheckFileContains(t, filepath.Join(root, "usr/bin/hello"), `v1`)
+
+	// The old files are left untouched
+	dpkg.Install([]string{filepath.Join(testdir, "2.0-interrupted.deb")})
+	testutil.CheckFileContains(t, filepath.Join(root, "usr/bin/hello"), `v1`)
+	testutil.CheckFileContains(t, filepath.Join(root, "usr/bin/hi"), `v1`)
+	checkNoTemporaryFiles(t, root)
+	testutil.CheckFileContains(t, filepath.Join(testdir, "dpkg/status"), `Package: hello
+Status: install ok installed
+Version: 1.0
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`)
+
+	// The new files replace the old ones
+	dpkg.Install([]string{filepath.Join(testdir, "2.0.deb")})
+	testutil.CheckFileContains(t, filepath.Join(root, "usr/bin/hello"), `v2`)
+	testutil.CheckFileContains(t, filepath.Join(root, "usr/share/hello.txt"), strings.Repeat("v2", 1000))
+	hello, err := os.Stat(filepath.Join(root, "usr/bin/hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hi, err := os.Stat(filepath.Join(root, "usr/bin/hi"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(hello, hi) {
+		t.Errorf("Expected usr/bin/hi to be a hard link to usr/bin/hello")
+	}
+	checkNoTemporaryFiles(t, root)
+	testutil.CheckFileContains(t, filepath.Join(testdir, "dpkg/status"), `Package: hello
+Status: install ok installed
+Version: 2.0
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`)
+}
+
+func TestInterruptedInstall(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testutil.PopulateTestDir(t, testdir, map[string][]byte{
+		"dpkg/status": []byte(``),
+	})
+	if err := os.MkdirAll(filepath.Join(testdir, "dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	control := func(name string) string {
+		return "Package: " + name + "\nVersion: 1.0\nArchitecture: all\nMaintainer: Julien Sobczak\nDescription: Say Hello\n"
+	}
+	writeDebian(t, filepath.Join(testdir, "world.deb"), control("world"), tarball(t, []entry{
+		{name: "./usr/", typeflag: tar.TypeDir},
+		{name: "./usr/bin/", typeflag: tar.TypeDir},
+		{name: "./usr/bin/hello", content: "world"},
+		{name: "./usr/bin/hi", typeflag: tar.TypeSymlink, linkname: "hello"},
+	}))
+	// The data stream stops in the middle of the last file
+	data := tarball(t, []entry{
+		{name: "./usr/", typeflag: tar.TypeDir},
+		{name: "./usr/bin/", typeflag: tar.TypeDir},
+		{name: "./usr/bin/hello", content: "hello"},
+		{name: "./usr/bin/hi", typeflag: tar.TypeSymlink, linkname: "hello"},
+		{name: "./usr/bin/hey", typeflag: tar.TypeSymlink, linkname: "hello"},
+		{name: "./usr/share/", typeflag: tar.TypeDir},
+		{name: "./usr/share/hello/", typeflag: tar.TypeDir},
+		{name: "./usr/share/hello/hello.txt", content: strings.Repeat("hello", 1000)},
+	})
+	writeDebian(t, filepath.Join(testdir, "hello-interrupted.deb"), control("hello"), data[:len(data)-2048])
+
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = filepath.Join(testdir, "root")
+	defer func() { dpkg.RootDir = "/" }()
+	root := dpkg.RootDir
+
+	dpkg.Install([]string{filepath.Join(testdir, "world.deb")})
+	dpkg.Install([]string{filepath.Join(testdir, "hello-interrupted.deb")})
+
+	// The files of the other package are left untouched
+	testutil.CheckFileContains(t, filepath.Join(root, "usr/bin/hello"), `world`)
+	if target, err := os.Readlink(filepath.Join(root, "usr/bin/hi")); err != nil || target != "hello" {
+		t.Errorf("Unexpected symlink usr/bin/hi: %q (%v)", target, err)
+	}
+	// Only the paths created by the failed installation are removed
+	for _, path := range []string{"usr/bin/hey", "usr/share"} {
+		if _, err := os.Lstat(filepath.Join(root, path)); !os.IsNotExist(err) {
+			t.Errorf("Unexpected path %s after a failed installation", path)
+		}
+	}
+	checkNoTemporaryFiles(t, root)
+	if db, err := dpkg.Load(); err != nil || db.GetPackage("hello") != nil {
+		t.Errorf("Package hello must not be installed (%v)", err)
+	}
+}
+
+/* Test Helpers */
+
+// checkNoTemporaryFiles fails if a file with the extension .dpkg-new remains.
+func checkNoTemporaryFiles(t *testing.T, root string) {
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(path, ".dpkg-new") {
+			t.Errorf("Unexpected temporary file %s", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// entry is a file inside data.tar.
+type entry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+// evilControl is the control file of the malicious archives.
+const evilControl = `Package: evil
+Version: 1.0
+Architecture: all
+Maintainer: Evil
+Description: Escape the root directory
+`
+
+// writeDebian creates a Debian archive with the given control file and data.tar.
+func writeDebian(t *testing.T, path string, control string, data []byte) {
+	content := writeMembers(t, []member{
+		{"debian-binary", []byte("2.0\n")},
+		{"control.tar", tarball(t, []entry{{name: "./control", content: control}})},
+		{"data.tar", data},
+	})
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// tarball creates an uncompressed tar archive.
+func tarball(t *testing.T, entries []entry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Linkname: e.linkname,
+			Mode:     0644,
+			Size:     int64(len(e.content)),
+		}
+		if e.typeflag == 0 {
+			hdr.Typeflag = tar.TypeReg
+		}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,148 @@
+package dpkg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// VerifyResult reports a file of an installed package that failed verification.
+type VerifyResult struct {
+	Package  string
+	Path     string // Ex: /usr/bin/hello
+	Conffile bool
+
+	Err              error // The file cannot be found (ex: no such file or directory)
+	ChecksumMismatch bool  // The content differs from the MD5 checksum recorded when unpacking
+}
+
+// String formats the result like dpkg --verify does.
+// Ex: "??5?????? c /etc/hello/settings.conf"
+func (r VerifyResult) String() string {
+	attr := ' '
+	if r.Conffile {
+		attr = 'c'
+	}
+	if r.Err != nil {
+		// Ex: "missing     /usr/bin/hello (No such file or directory)"
+		return fmt.Sprintf("%-9s %c %s (%s)", "missing", attr, r.Path, errorReason(r.Err))
+	}
+	return fmt.Sprintf("??5?????? %c %s", attr, r.Path)
+}
+
+// errorReason returns the system error message like strerror.
+func errorReason(err error) string {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		// Ex: "no such file or directory" => "No such file or directory"
+		msg := errno.Error()
+		return strings.ToUpper(msg[:1]) + msg[1:]
+	}
+	return err.Error()
+}
+
+// Verify checks the files of the installed packages (all packages when none is given)
+// against the database and prints the problems (like dpkg --verify).
+func Verify(pkgNames []string) {
+	// Read the database
+	db, err := Load()
+	if err != nil {
+		fmt.Printf("Unable to read the database: %v", err)
+		os.Exit(1)
+	}
+	results, err := db.Verify(pkgNames)
+	for _, result := range results {
+		fmt.Println(result)
+	}
+	if err != nil {
+		fmt.Printf("dpkg: error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// Verify checks the files of the installed packages (all packages when none is given).
+// Only files failing verification are returned.
+func (d *Directory) Verify(pkgNames []string) ([]VerifyResult, error) {
+	var pkgs []*PackageInfo
+	if len(pkgNames) == 0 {
+		for _, pkg := range d.Packages {
+			if pkg.Status != "not-installed" && pkg.Status != "config-files" {
+				pkgs = append(pkgs, pkg)
+			}
+		}
+	}
+	var notInstalled []string
+	for _, pkgName := range pkgNames {
+		pkg := d.GetPackage(pkgName)
+		if pkg == nil || pkg.Status == "not-installed" || pkg.Status == "config-files" {
+			notInstalled = append(notInstalled, pkgName)
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+
+	var results []VerifyResult
+	for _, pkg := range pkgs {
+		pkgResults, err := pkg.Verify()
+		if err != nil {
+			return results, err
+		}
+		results = append(results, pkgResults...)
+	}
+	if len(notInstalled) > 0 {
+		return results, fmt.Errorf("package '%s' is not installed", notInstalled[0])
+	}
+	return results, nil
+}
+
+// Verify checks the files of the package are present and unmodified.
+// Conffiles are compared with the version shipped by the package.
+func (p *PackageInfo) Verify() ([]VerifyResult, error) {
+	conffileHashes := ParseConffileHashes(p.Paragraph.Value("Conffiles"))
+
+	var results []VerifyResult
+	for _, file := range p.Files {
+		if file == "/." {
+			continue
+		}
+		result := VerifyResult{
+			Package:  p.Name(),
+			Path:     file,
+			Conffile: p.isConffile(file),
+		}
+		path := filepath.Join(RootDir, file)
+		info, err := os.Lstat(path)
+		if err != nil {
+			result.Err = err
+			results = append(results, result)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		expected, ok := conffileHashes[file]
+		if !ok {
+			expected, ok = p.MD5sums[file]
+		}
+		if !ok {
+			continue
+		}
+		actual, err := fileMD5(path)
+		if errors.Is(err, os.ErrPermission) {
+			// The checksum cannot be checked
+			continue
+		}
+		if err != nil {
+			return results, err
+		}
+		if actual != expected {
+			result.ChecksumMismatch = true
+			results = append(results, result)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,100 @@
+package dpkg_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+	"github.com/julien-sobczak/linux-packages-from-scratch/testutil"
+)
+
+func TestVerify(t *testing.T) {
+	testdir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(testdir)
+	t.Logf("Working in temp dir %s", testdir)
+
+	testfiles := map[string][]byte{
+		"2.1-1/DEBIAN/control": []byte(`Package: hello
+Version: 2.1-1
+Architecture: all
+Maintainer: Julien Sobczak
+Description: Say Hello
+`),
+		"2.1-1/DEBIAN/conffiles": []byte(`/etc/hello/settings.conf
+`),
+		"2.1-1/usr/bin/hello": []byte(`#!/bin/sh
+echo "Hello";
+`),
+		"2.1-1/usr/share/doc/hello/README": []byte(`Say Hello`),
+		"2.1-1/usr/share/doc/hello/NEWS":   []byte(`First release`),
+		"2.1-1/etc/hello/settings.conf":    []byte("lang=en\n"),
+		"dpkg/status":                      []byte(``),
+	}
+	testutil.PopulateTestDir(t, testdir, testfiles)
+	if err := os.MkdirAll(filepath.Join(testdir, "dpkg/info"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(testdir, "hello.deb")
+	dpkg.Build(filepath.Join(testdir, "2.1-1"), archive)
+	dpkg.VarDir = filepath.Join(testdir, "dpkg")
+	dpkg.RootDir = filepath.Join(testdir, "root")
+	defer func() { dpkg.RootDir = "/" }()
+	dpkg.Install([]string{archive})
+	root := dpkg.RootDir
+
+	verify := func(pkgNames ...string) ([]string, error) {
+		db, err := dpkg.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := db.Verify(pkgNames)
+		var lines []string
+		for _, result := range results {
+			lines = append(lines, result.String())
+		}
+		return lines, err
+	}
+
+	// A fresh installation is valid
+	if lines, err := verify(); err != nil || len(lines) != 0 {
+		t.Errorf("Unexpected verification results: %v (%v)", lines, err)
+	}
+
+	// Break the installation
+	testutil.PopulateTestDir(t, root, map[string][]byte{
+		"usr/bin/hello":           []byte(`#!/bin/sh`),
+		"etc/hello/settings.conf": []byte("lang=fr\n"),
+	})
+	if err := os.Remove(filepath.Join(root, "usr/share/doc/hello/README")); err != nil {
+		t.Fatal(err)
+	}
+	// The modification time is not checked
+	if err := os.Chtimes(filepath.Join(root, "usr/share/doc/hello/NEWS"), time.Unix(0, 0), time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err := verify("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"??5?????? c /etc/hello/settings.conf",
+		"??5??????   /usr/bin/hello",
+		"missing     /usr/share/doc/hello/README (No such file or directory)",
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected verification results:\n%s\nExpected:\n%s", strings.Join(lines, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// Unknown packages are reported
+	if _, err := verify("unknown"); err == nil || err.Error() != "package 'unknown' is not installed" {
+		t.Errorf("Unexpected error for an unknown package: %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package dpkg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version represents a Debian version [epoch:]upstream_version[-debian_revision].
+type Version struct {
+	Epoch    int
+	Upstream string
+	Revision string
+}
+
+func ParseVersion(value string) Version {
+	var v Version
+	value = strings.TrimSpace(value)
+	if i := strings.Index(value, ":"); i >= 0 {
+		v.Epoch, _ = strconv.Atoi(value[:i])
+		value = value[i+1:]
+	}
+	if i := strings.LastIndex(value, "-"); i >= 0 {
+		v.Revision = value[i+1:]
+		value = value[:i]
+	}
+	v.Upstream = value
+	return v
+}
+
+// ValidateVersion checks the syntax of a version like dpkg does.
+func ValidateVersion(value string) error {
+	if value == "" {
+		return fmt.Errorf("version string is empty")
+	}
+	if strings.ContainsAny(value, " \t") {
+		return fmt.Errorf("version string has embedded spaces")
+	}
+	if i := strings.Index(value, ":"); i >= 0 {
+		if epoch, err := strconv.Atoi(value[:i]); err != nil || epoch < 0 {
+			return fmt.Errorf("epoch in version is not number")
+		}
+		if value[i+1:] == "" {
+			return fmt.Errorf("nothing after colon in version number")
+		}
+	}
+	v := ParseVersion(value)
+	if v.Upstream == "" {
+		return fmt.Errorf("empty upstream version")
+	}
+	if !isDigit(v.Upstream[0]) {
+		return fmt.Errorf("version number does not start with digit")
+	}
+	for _, c := range v.Upstream {
+		if !strings.ContainsRune(versionChars, c) && c != '-' && c != ':' {
+			return fmt.Errorf("invalid character in version number")
+		}
+	}
+	if i := strings.LastIndex(value, "-"); i >= 0 && v.Revision == "" {
+		return fmt.Errorf("revision number is empty")
+	}
+	for _, c := range v.Revision {
+		if !strings.ContainsRune(versionChars, c) {
+			return fmt.Errorf("invalid character in revision number")
+		}
+	}
+	return nil
+}
+
+// versionChars lists the characters allowed in upstream versions and revisions.
+const versionChars = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ.+~"
+
+func (v Version) String() string {
+	res := v.Upstream
+	if v.Epoch > 0 {
+		res = strconv.Itoa(v.Epoch) + ":" + res
+	}
+	if v.Revision != "" {
+		res += "-" + v.Revision
+	}
+	return res
+}
+
+// CompareVersions returns -1, 0 or 1 if a is respectively older, equal or newer than b.
+// The algorithm is described in the Debian Policy Manual, section 5.6.12.
+func CompareVersions(a, b string) int {
+	va := ParseVersion(a)
+	vb := ParseVersion(b)
+	if va.Epoch != vb.Epoch {
+		if va.Epoch < vb.Epoch {
+			return -1
+		}
+		return 1
+	}
+	if res := compareFragment(va.Upstream, vb.Upstream); res != 0 {
+		return res
+	}
+	return compareFragment(va.Revision, vb.Revision)
+}
+
+// compareFragment compares alternatively non-digit and digit parts.
+func compareFragment(a, b string) int {
+	for a != "" || b != "" {
+		// Compare the non-digit prefixes lexically (with ~ sorting before everything)
+		i, j := 0, 0
+		for i < len(a) && !isDigit(a[i]) {
+			i++
+		}
+		for j < len(b) && !isDigit(b[j]) {
+			j++
+		}
+		if res := compareLexical(a[:i], b[:j]); res != 0 {
+			return res
+		}
+		a, b = a[i:], b[j:]
+
+		// Compare the digit prefixes numerically
+		i, j = 0, 0
+		for i < len(a) && isDigit(a[i]) {
+			i++
+		}
+		for j < len(b) && isDigit(b[j]) {
+			j++
+		}
+		na, _ := strconv.ParseUint(a[:i], 10, 64)
+		nb, _ := strconv.ParseUint(b[:j], 10, 64)
+		if na != nb {
+			if na < nb {
+				return -1
+			}
+			return 1
+		}
+		a, b = a[i:], b[j:]
+	}
+	return 0
+}
+
+func compareLexical(a, b string) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var ca, cb int
+		if i < len(a) {
+			ca = lexicalOrder(a[i])
+		}
+		if i < len(b) {
+			cb = lexicalOrder(b[i])
+		}
+		if ca != cb {
+			if ca < cb {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// lexicalOrder returns the weight of a character. Letters sort earlier than non-letters
+// and ~ sorts before anything, even the end of a part.
+func lexicalOrder(c byte) int {
+	switch {
+	case c == '~':
+		return -1
+	case isDigit(c):
+		return 0
+	case (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'):
+		return int(c)
+	default:
+		return int(c) + 256
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
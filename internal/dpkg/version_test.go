@@ -0,0 +1,62 @@
+package dpkg_test
+
+import (
+	"testing"
+
+	"github.com/julien-sobczak/linux-packages-from-scratch/internal/dpkg"
+)
+
+func TestCompareVersions(t *testing.T) {
+	var tests = []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.1-1", "1.1-1", 0},
+		{"1.1-1", "2.1-1", -1},
+		{"1.10", "1.9", 1},
+		{"1:1.0", "2.0", 1},
+		{"1.0~rc1", "1.0", -1},
+		{"1.0", "1.0+deb10u1", -1},
+		{"5.28.1-6+deb10u1", "5.28.1-6", 1},
+		{"2:8.2.2434-3", "2:8.2.2434-3+b1", -1},
+		{"1.0a", "1.0-", 1},
+	}
+
+	for _, tt := range tests {
+		actual := dpkg.CompareVersions(tt.a, tt.b)
+		if actual != tt.expected {
+			t.Errorf("CompareVersions(%q, %q): expected %d, actual %d", tt.a, tt.b, tt.expected, actual)
+		}
+	}
+}
+
+func TestValidateVersion(t *testing.T) {
+	var tests = []struct {
+		version  string
+		expected string
+	}{
+		{"1.1-1", ""},
+		{"2:8.2.2434-3+b1", ""},
+		{"1.0~rc1", ""},
+		{"1.0-2-3", ""},
+		{"", "version string is empty"},
+		{"1.0 1", "version string has embedded spaces"},
+		{"a:1.0", "epoch in version is not number"},
+		{"1:", "nothing after colon in version number"},
+		{"v1.0", "version number does not start with digit"},
+		{"1.0_1", "invalid character in version number"},
+		{"1.0-", "revision number is empty"},
+		{"1.0-1_2", "invalid character in revision number"},
+	}
+
+	for _, tt := range tests {
+		err := dpkg.ValidateVersion(tt.version)
+		actual := ""
+		if err != nil {
+			actual = err.Error()
+		}
+		if actual != tt.expected {
+			t.Errorf("ValidateVersion(%q): expected %q, actual %q", tt.version, tt.expected, actual)
+		}
+	}
+}